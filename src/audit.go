@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/xml"
+	"sync"
+	"time"
+)
+
+// Audit operations recorded for product mutations
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditReset   = "reset" // the whole store was cleared; recorded with product ID 0
+)
+
+// defaultAuditCapacity bounds the number of audit entries kept in memory
+const defaultAuditCapacity = 1000
+
+// AuditEntry records a single product mutation
+type AuditEntry struct {
+	XMLName   xml.Name  `json:"-" xml:"entry"`
+	Timestamp time.Time `json:"timestamp" xml:"timestamp"`
+	Operation string    `json:"operation" xml:"operation"`
+	ProductID int32     `json:"productId" xml:"productId"`
+	RequestID string    `json:"requestId,omitempty" xml:"requestId,omitempty"`
+}
+
+// AuditLog is a thread-safe, fixed-size ring buffer of audit entries
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	next    int  // index the next entry is written to
+	full    bool // whether the buffer has wrapped around
+}
+
+// NewAuditLog creates an audit log holding at most capacity entries
+func NewAuditLog(capacity int) *AuditLog {
+	return &AuditLog{entries: make([]AuditEntry, capacity)}
+}
+
+// Record appends an entry, overwriting the oldest once the buffer is full
+func (a *AuditLog) Record(operation string, productID int32, requestID string) {
+	entry := AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Operation: operation,
+		ProductID: productID,
+		RequestID: requestID,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries[a.next] = entry
+	a.next = (a.next + 1) % len(a.entries)
+	if a.next == 0 {
+		a.full = true
+	}
+}
+
+// Recent returns up to n entries, newest first
+func (a *AuditLog) Recent(n int) []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	size := a.next
+	if a.full {
+		size = len(a.entries)
+	}
+	if n > size {
+		n = size
+	}
+	recent := make([]AuditEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (a.next - i + len(a.entries)) % len(a.entries)
+		recent = append(recent, a.entries[idx])
+	}
+	return recent
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Import modes accepted by POST /import
+const (
+	importModeMerge   = "merge"
+	importModeReplace = "replace"
+)
+
+// HandleExport handles GET /export, returning every product, including
+// soft-deleted ones, and the next ID as a JSON attachment that POST /import
+// (or DATA_FILE) accepts
+func (s *Server) HandleExport(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := s.store.Snapshot()
+	if err != nil {
+		slog.Error("Error exporting store", "error", err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, ErrCodeInternal, "Error exporting store")
+		return
+	}
+
+	filename := fmt.Sprintf("products-%s.json", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(snapshot); err != nil {
+		slog.Error("Error encoding export", "error", err)
+	}
+}
+
+// HandleImport handles POST /import, loading a document produced by GET
+// /export. ?mode=merge (the default) overwrites products with matching IDs and
+// keeps the rest; ?mode=replace discards the current store first. Every product
+// is validated before anything is written, and the store's stats are returned.
+func (s *Server) HandleImport(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = importModeMerge
+	}
+	if mode != importModeMerge && mode != importModeReplace {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "Invalid mode: must be merge or replace")
+		return
+	}
+
+	var snapshot storeSnapshot
+	if !s.decodeJSONBody(w, r, &snapshot) {
+		return
+	}
+
+	// Field names are prefixed with the product's position, e.g. "products[2].price"
+	var fieldErrors []FieldError
+	for i, product := range snapshot.Products {
+		if product == nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("products[%d]", i), Message: "product must not be null"})
+			continue
+		}
+		for _, fe := range s.validate(product) {
+			fe.Field = fmt.Sprintf("products[%d].%s", i, fe.Field)
+			fieldErrors = append(fieldErrors, fe)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationError(w, r, fieldErrors)
+		return
+	}
+
+	// Hold off creates until the import is stored, so together they cannot
+	// exceed MAX_PRODUCTS
+	unlock := s.lockCreates()
+	defer unlock()
+	if s.maxProducts > 0 {
+		if total := s.importedTotal(snapshot, mode == importModeReplace); total > s.maxProducts {
+			writeErrorResponse(w, r, http.StatusInsufficientStorage, ErrCodeProductLimitReached,
+				fmt.Sprintf("Product limit reached: at most %d products allowed, the import would leave %d", s.maxProducts, total))
+			return
+		}
+	}
+
+	if err := s.store.Import(snapshot, mode == importModeReplace); errors.Is(err, ErrInvalidSnapshot) {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, fmt.Sprintf("Invalid import: %v", err))
+		return
+	} else if err != nil {
+		slog.Error("Error importing store", "error", err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, ErrCodeInternal, "Error importing store")
+		return
+	}
+	// Imported products arrive with their own stock, so units reserved from
+	// the products they overwrite must not be handed back on release or expiry.
+	// A replace also drops the products that cached responses describe, so a
+	// retried create must run again rather than replay, as after a reset.
+	if mode == importModeReplace {
+		s.reservations.DropAll()
+		s.idempotency.Clear()
+	} else {
+		ids := make([]int32, len(snapshot.Products))
+		for i, product := range snapshot.Products {
+			ids[i] = product.ID
+		}
+		s.reservations.DropProducts(ids)
+	}
+	slog.Warn("Products imported", "mode", mode, "products", len(snapshot.Products), "request_id", RequestIDFromContext(r.Context()))
+	writeResponse(w, r, http.StatusOK, s.store.Stats())
+}
+
+// importedTotal returns how many products, soft-deleted ones included, the
+// store would hold after importing snapshot. A merge keeps every current
+// product whose ID the snapshot doesn't overwrite.
+func (s *Server) importedTotal(snapshot storeSnapshot, replace bool) int {
+	if replace {
+		return len(snapshot.Products)
+	}
+	ids := make(map[int32]struct{}, len(snapshot.Products))
+	for _, product := range s.store.ListAllProducts() {
+		ids[product.ID] = struct{}{}
+	}
+	for _, product := range snapshot.Products {
+		ids[product.ID] = struct{}{}
+	}
+	return len(ids)
+}
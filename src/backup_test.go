@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Desk","price":199.5,"currency":"EUR","stock":2,"categories":["Office"]}`), http.StatusCreated)
+			assertStatus(t, serve(h, "PUT", "/v1/products/1", `{"name":"Gaming Laptop","price":1299,"stock":4}`), http.StatusOK)
+			assertStatus(t, serve(h, "DELETE", "/v1/products/2", ""), http.StatusNoContent)
+
+			rec := serve(h, "GET", "/v1/export", "")
+			assertStatus(t, rec, http.StatusOK)
+			if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="products-`) || !strings.HasSuffix(cd, `.json"`) {
+				t.Errorf("Content-Disposition = %q, want a JSON attachment", cd)
+			}
+			exported := rec.Body.String()
+			snapshot := decodeBody[storeSnapshot](t, rec)
+			if snapshot.NextID != 5 || len(snapshot.Products) != 4 {
+				t.Fatalf("export has nextId %d and %d products, want 5 and 4 (deleted included)", snapshot.NextID, len(snapshot.Products))
+			}
+
+			assertStatus(t, serve(h, "DELETE", "/v1/products", ""), http.StatusNoContent)
+			if page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", "")); page.Total != 0 {
+				t.Fatalf("total after reset = %d, want 0", page.Total)
+			}
+
+			rec = serve(h, "POST", "/v1/import?mode=replace", exported)
+			assertStatus(t, rec, http.StatusOK)
+			if stats := decodeBody[StoreStats](t, rec); stats.Products != 3 {
+				t.Errorf("stats after import = %+v, want 3 live products", stats)
+			}
+			if got := serve(h, "GET", "/v1/export", "").Body.String(); got != exported {
+				t.Errorf("re-export differs:\n%s\nwant:\n%s", got, exported)
+			}
+			if product := getProduct(t, h, "1"); product.Name != "Gaming Laptop" || product.Version != 2 {
+				t.Errorf("restored product 1 = %+v", product)
+			}
+			assertError(t, serve(h, "GET", "/v1/products/2", ""), http.StatusNotFound, ErrCodeProductNotFound)
+			assertStatus(t, serve(h, "POST", "/v1/products/2/restore", ""), http.StatusOK)
+
+			// IDs continue after the imported nextId
+			rec = serve(h, "POST", "/v1/products", `{"name":"Chair","price":1,"stock":1}`)
+			assertStatus(t, rec, http.StatusCreated)
+			if created := decodeBody[Product](t, rec); created.ID != 5 {
+				t.Errorf("next created ID = %d, want 5", created.ID)
+			}
+		})
+	}
+}
+
+func TestImportModes(t *testing.T) {
+	const body = `{"nextId":10,"products":[{"id":1,"name":"Refurbished Laptop","price":499,"stock":1},{"id":9,"name":"Desk","price":199,"stock":2}]}`
+	tests := []struct {
+		mode    string
+		wantIDs []int32
+	}{
+		{"", []int32{1, 2, 3, 9}},
+		{"merge", []int32{1, 2, 3, 9}},
+		{"replace", []int32{1, 9}},
+	}
+	for backend, configure := range storeBackends(t) {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.mode, func(t *testing.T) {
+				_, h := newTestServer(t, configure)
+				assertStatus(t, serve(h, "POST", "/v1/import?mode="+tt.mode, body), http.StatusOK)
+				page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", ""))
+				if got := productIDs(page.Items); !slices.Equal(got, tt.wantIDs) {
+					t.Errorf("IDs = %v, want %v", got, tt.wantIDs)
+				}
+				if product := getProduct(t, h, "1"); product.Name != "Refurbished Laptop" {
+					t.Errorf("product 1 = %+v, want the imported one", product)
+				}
+			})
+		}
+	}
+}
+
+func TestImportRejections(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		status   int
+		wantCode ErrorCode
+	}{
+		{"unknown mode", "/v1/import?mode=append", `{"nextId":1,"products":[]}`, http.StatusBadRequest, ErrCodeInvalidParameter},
+		{"invalid product", "/v1/import", `{"nextId":2,"products":[{"id":1,"name":"","price":1,"stock":1}]}`, http.StatusUnprocessableEntity, ErrCodeValidationFailed},
+		{"null product", "/v1/import", `{"nextId":2,"products":[null]}`, http.StatusUnprocessableEntity, ErrCodeValidationFailed},
+		{"missing id", "/v1/import", `{"nextId":2,"products":[{"name":"Desk","price":1,"stock":1}]}`, http.StatusBadRequest, ErrCodeInvalidBody},
+		{"duplicate id", "/v1/import", `{"nextId":2,"products":[{"id":1,"name":"A","price":1,"stock":1},{"id":1,"name":"B","price":1,"stock":1}]}`, http.StatusBadRequest, ErrCodeInvalidBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := serve(h, "POST", tt.target, tt.body)
+			assertError(t, rec, tt.status, tt.wantCode)
+			if tt.name == "invalid product" {
+				assertFieldError(t, rec, "products[0].name")
+			}
+			// Nothing is written when an import is rejected
+			if page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", "")); page.Total != 3 {
+				t.Errorf("total = %d after a rejected import, want 3", page.Total)
+			}
+		})
+	}
+}
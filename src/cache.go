@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// cachedProduct is a product as last served by GET /products/{productId},
+// along with its ETag and encoded JSON body
+type cachedProduct struct {
+	product   *Product
+	etag      string
+	body      []byte
+	expiresAt time.Time
+}
+
+// ResponseCache holds recently served products for CACHE_TTL so repeated reads
+// skip the store, ETag hashing and JSON encoding. Entries are dropped as soon
+// as their product is written.
+type ResponseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int32]*cachedProduct
+	// generation is bumped by every invalidation, so a read that raced with a
+	// write cannot store the product it fetched before the write
+	generation uint64
+}
+
+// NewResponseCache creates a cache whose entries expire after ttl
+func NewResponseCache(ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		ttl:     ttl,
+		entries: make(map[int32]*cachedProduct),
+	}
+}
+
+// Get returns the unexpired entry for id, if any
+func (c *ResponseCache) Get(id int32) (*cachedProduct, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[id]
+	if !exists {
+		return nil, false
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		delete(c.entries, id)
+		return nil, false
+	}
+	return entry, true
+}
+
+// Generation returns the current generation, to be passed to Put after
+// reading the product from the store
+func (c *ResponseCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Put caches product under its ID unless a write has happened since
+// generation was read
+func (c *ResponseCache) Put(product *Product, etag string, generation uint64) {
+	body, err := json.Marshal(product)
+	if err != nil {
+		return
+	}
+	body = append(body, '\n') // match json.Encoder output
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	c.entries[product.ID] = &cachedProduct{
+		product:   product,
+		etag:      etag,
+		body:      body,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+}
+
+// Invalidate drops the entry for id
+func (c *ResponseCache) Invalidate(id int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.entries, id)
+}
+
+// Clear drops every entry
+func (c *ResponseCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[int32]*cachedProduct)
+}
+
+// enableCache turns on the GET /products/{productId} response cache. Every
+// successful store write, including those made by reservations, drops the
+// written product's entry.
+func (s *Server) enableCache(ttl time.Duration) {
+	s.cache = NewResponseCache(ttl)
+	s.observeStore(func(event ProductEvent) {
+		if event.Type == EventReset || event.Type == EventImport {
+			s.cache.Clear()
+			return
+		}
+		s.cache.Invalidate(event.ID)
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	cache := NewResponseCache(time.Minute)
+	product := &Product{ID: 1, Name: "Laptop", Price: 99999, Currency: "USD", Stock: 10}
+
+	cache.Put(product, `"v1"`, cache.Generation())
+	entry, ok := cache.Get(1)
+	if !ok || entry.product != product || entry.etag != `"v1"` {
+		t.Fatalf("Get(1) = %+v, %v; want the stored entry", entry, ok)
+	}
+
+	// A read that started before a write must not cache what it fetched
+	generation := cache.Generation()
+	cache.Invalidate(1)
+	cache.Put(product, `"v1"`, generation)
+	if _, ok := cache.Get(1); ok {
+		t.Error("stale Put was cached after an invalidation")
+	}
+
+	expiring := NewResponseCache(time.Nanosecond)
+	expiring.Put(product, `"v1"`, expiring.Generation())
+	time.Sleep(time.Millisecond)
+	if _, ok := expiring.Get(1); ok {
+		t.Error("expired entry was served")
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{
+			name: "replace", method: "PUT", target: "/v1/products/1",
+			body: `{"name":"Gaming Laptop","price":1299.5,"stock":4}`,
+		},
+		{
+			name: "details", method: "POST", target: "/v1/products/1/details",
+			body: `{"name":"Gaming Laptop","price":1299.5,"stock":4}`,
+		},
+		{name: "purchase", method: "POST", target: "/v1/products/1/purchase", body: `{"quantity":3}`},
+		{name: "stock adjust", method: "POST", target: "/v1/products/1/stock/adjust", body: `{"delta":5}`},
+		{name: "reserve", method: "POST", target: "/v1/products/1/reserve", body: `{"quantity":2}`},
+	}
+	for backend, configure := range storeBackends(t) {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				_, h := newTestServer(t, configure, func(cfg *Config) { cfg.CacheTTL = time.Minute })
+				before := serve(h, "GET", "/v1/products/1", "")
+				assertStatus(t, before, http.StatusOK)
+				cached := decodeBody[Product](t, before)
+
+				rec := serve(h, tt.method, tt.target, tt.body)
+				if rec.Code >= 300 {
+					t.Fatalf("%s %s = %d: %s", tt.method, tt.target, rec.Code, rec.Body.String())
+				}
+
+				after := serve(h, "GET", "/v1/products/1", "")
+				assertStatus(t, after, http.StatusOK)
+				product := decodeBody[Product](t, after)
+				if product.Version == cached.Version {
+					t.Errorf("served version %d after a write, want a newer one", product.Version)
+				}
+				if after.Header().Get("ETag") == before.Header().Get("ETag") {
+					t.Errorf("ETag %s unchanged after a write", after.Header().Get("ETag"))
+				}
+			})
+		}
+	}
+}
+
+func TestCacheRemovedProducts(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"delete", "DELETE", "/v1/products/1", ""},
+		{"reset", "DELETE", "/v1/products", ""},
+		{"import replace", "POST", "/v1/import?mode=replace", `{"nextId":3,"products":[{"id":2,"name":"Mouse","price":29.99,"stock":50}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) { cfg.CacheTTL = time.Minute })
+			getProduct(t, h, "1")
+
+			rec := serve(h, tt.method, tt.target, tt.body)
+			if rec.Code >= 300 {
+				t.Fatalf("%s %s = %d: %s", tt.method, tt.target, rec.Code, rec.Body.String())
+			}
+			assertError(t, serve(h, "GET", "/v1/products/1", ""), http.StatusNotFound, ErrCodeProductNotFound)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// ProductComparison is the response of GET /products/compare: the products
+// found, in request order, the IDs that do not exist, and a summary of how
+// the found products compare
+type ProductComparison struct {
+	XMLName xml.Name          `json:"-" xml:"comparison"`
+	Items   []*Product        `json:"items" xml:"items>product"`
+	Missing []int32           `json:"missing" xml:"missing>id"`
+	Summary ComparisonSummary `json:"summary" xml:"summary"`
+}
+
+// ComparisonSummary picks out the compared products that stand out. Ties go
+// to the product listed first. Prices are only compared when every product
+// shares a currency, so the price fields are omitted otherwise, and every
+// field is omitted when no product was found.
+type ComparisonSummary struct {
+	CheapestID      int32  `json:"cheapestId,omitempty" xml:"cheapestId,omitempty"`
+	MostExpensiveID int32  `json:"mostExpensiveId,omitempty" xml:"mostExpensiveId,omitempty"`
+	MostInStockID   int32  `json:"mostInStockId,omitempty" xml:"mostInStockId,omitempty"`
+	MinPrice        *Cents `json:"minPrice,omitempty" xml:"minPrice,omitempty"`
+	MaxPrice        *Cents `json:"maxPrice,omitempty" xml:"maxPrice,omitempty"`
+}
+
+// summarizeComparison computes the summary of products
+func summarizeComparison(products []*Product) ComparisonSummary {
+	var summary ComparisonSummary
+	if len(products) == 0 {
+		return summary
+	}
+
+	cheapest, priciest, mostStock := products[0], products[0], products[0]
+	sameCurrency := true
+	for _, product := range products[1:] {
+		if product.Price < cheapest.Price {
+			cheapest = product
+		}
+		if product.Price > priciest.Price {
+			priciest = product
+		}
+		if product.Stock > mostStock.Stock {
+			mostStock = product
+		}
+		if product.Currency != products[0].Currency {
+			sameCurrency = false
+		}
+	}
+	summary.MostInStockID = mostStock.ID
+	if sameCurrency {
+		summary.CheapestID, summary.MostExpensiveID = cheapest.ID, priciest.ID
+		summary.MinPrice, summary.MaxPrice = &cheapest.Price, &priciest.Price
+	}
+	return summary
+}
+
+// HandleCompareProducts handles GET /products/compare?ids=1,2,3, returning up
+// to maxCompareIDs products side by side with a summary. IDs that do not exist
+// are reported under missing rather than failing the request.
+func (s *Server) HandleCompareProducts(w http.ResponseWriter, r *http.Request) {
+	value := r.URL.Query().Get("ids")
+	if value == "" {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "Invalid ids: at least one product ID is required")
+		return
+	}
+	ids, err := parseIDList(value, maxCompareIDs)
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, err.Error())
+		return
+	}
+
+	set := s.lookupProducts(ids)
+	writeResponse(w, r, http.StatusOK, ProductComparison{
+		Items:   set.Items,
+		Missing: set.Missing,
+		Summary: summarizeComparison(set.Items),
+	})
+}
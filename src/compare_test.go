@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHandleCompareProducts(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			assertStatus(t, serve(h, "DELETE", "/v1/products/2", ""), http.StatusNoContent)
+
+			// Items keep request order; duplicates collapse and deleted products count as missing
+			rec := serve(h, "GET", "/v1/products/compare?ids=3,%201,99,3,2", "")
+			assertStatus(t, rec, http.StatusOK)
+			comparison := decodeBody[ProductComparison](t, rec)
+			if got := productIDs(comparison.Items); !slices.Equal(got, []int32{3, 1}) {
+				t.Errorf("items = %v, want [3 1]", got)
+			}
+			if !slices.Equal(comparison.Missing, []int32{99, 2}) {
+				t.Errorf("missing = %v, want [99 2]", comparison.Missing)
+			}
+			summary := comparison.Summary
+			if summary.CheapestID != 3 || summary.MostExpensiveID != 1 || summary.MostInStockID != 3 {
+				t.Errorf("summary = %+v, want Keyboard cheapest and most in stock, Laptop most expensive", summary)
+			}
+			if summary.MinPrice == nil || *summary.MinPrice != 7999 || summary.MaxPrice == nil || *summary.MaxPrice != 99999 {
+				t.Errorf("price range = %v..%v, want 7999..99999", summary.MinPrice, summary.MaxPrice)
+			}
+
+			// With nothing found the lists are empty, not null, and the summary is empty
+			rec = serve(h, "GET", "/v1/products/compare?ids=98,99", "")
+			assertStatus(t, rec, http.StatusOK)
+			if body := rec.Body.String(); !strings.Contains(body, `"items":[]`) || !strings.Contains(body, `"summary":{}`) {
+				t.Errorf("body = %s, want empty items and summary", body)
+			}
+		})
+	}
+}
+
+func TestCompareMixedCurrencies(t *testing.T) {
+	_, h := newTestServer(t)
+	assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Desk","price":1,"currency":"EUR","stock":99}`), http.StatusCreated)
+
+	summary := decodeBody[ProductComparison](t, serve(h, "GET", "/v1/products/compare?ids=1,4", "")).Summary
+	if summary.CheapestID != 0 || summary.MostExpensiveID != 0 || summary.MinPrice != nil || summary.MaxPrice != nil {
+		t.Errorf("summary = %+v, want prices left uncompared across currencies", summary)
+	}
+	if summary.MostInStockID != 4 {
+		t.Errorf("most in stock = %d, want 4", summary.MostInStockID)
+	}
+}
+
+func TestCompareTies(t *testing.T) {
+	_, h := newTestServer(t)
+	assertStatus(t, serve(h, "PUT", "/v1/products/3", `{"name":"Keyboard","price":29.99,"stock":50}`), http.StatusOK)
+
+	// Mouse and Keyboard now match on price and stock; the first listed wins
+	for _, ids := range []string{"2,3", "3,2"} {
+		summary := decodeBody[ProductComparison](t, serve(h, "GET", "/v1/products/compare?ids="+ids, "")).Summary
+		first := int32(ids[0] - '0')
+		if summary.CheapestID != first || summary.MostExpensiveID != first || summary.MostInStockID != first {
+			t.Errorf("ids=%s summary = %+v, want every tie to go to %d", ids, summary, first)
+		}
+	}
+}
+
+func TestCompareInvalidIDs(t *testing.T) {
+	_, h := newTestServer(t)
+	var tooMany []string
+	for i := range maxCompareIDs + 1 {
+		tooMany = append(tooMany, strconv.Itoa(i+1))
+	}
+	atCap := strings.Join(tooMany[:maxCompareIDs], ",")
+	assertStatus(t, serve(h, "GET", "/v1/products/compare?ids="+atCap, ""), http.StatusOK)
+
+	tests := []struct {
+		name, query, wantMessage string
+	}{
+		{"missing ids", "", "Invalid ids: at least one product ID is required"},
+		{"empty ids", "?ids=", "Invalid ids: at least one product ID is required"},
+		{"over the cap", "?ids=" + strings.Join(tooMany, ","), "Too many ids: at most " + strconv.Itoa(maxCompareIDs) + " allowed"},
+		{"not a number", "?ids=1,abc", `Invalid ids: "abc" is not a valid product ID`},
+		{"zero", "?ids=0", `Invalid ids: "0" is not a valid product ID`},
+		{"trailing comma", "?ids=1,", `Invalid ids: "" is not a valid product ID`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, "GET", "/v1/products/compare"+tt.query, "")
+			assertError(t, rec, http.StatusBadRequest, ErrCodeInvalidParameter)
+			if msg := decodeBody[Error](t, rec).Message; msg != tt.wantMessage {
+				t.Errorf("message = %q, want %q", msg, tt.wantMessage)
+			}
+		})
+	}
+}
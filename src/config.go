@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every setting read from the environment at startup. LoadConfig
+// fills it in; DefaultConfig gives the values used when nothing is set.
+type Config struct {
+	// HTTP server
+	Port              string
+	APIPrefix         string   // base path for API routes, e.g. "/api"; empty mounts them at the root
+	AllowedOrigins    []string // CORS origins; "*" allows any
+	TLSCert           string   // TLS certificate path; empty serves plain HTTP
+	TLSKey            string
+	APIKey            string         // key required for write requests; empty disables auth
+	TrustedProxies    []netip.Prefix // proxies whose X-Forwarded-For and X-Real-IP are believed
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	RequestTimeout    time.Duration
+	ShutdownTimeout   time.Duration // how long shutdown waits for in-flight requests
+
+	// Request limits
+	RateLimit        float64 // per-client requests/second
+	MaxBodyBytes     int64
+	MaxQueryBytes    int      // 0 disables the limit
+	MaxQueryParams   int      // 0 disables the limit
+	RequestEncodings []string // accepted request Content-Encodings besides identity
+	StrictJSON       bool     // reject JSON bodies with unknown fields rather than ignore them
+
+	// Logging and diagnostics
+	LogFormat     string // text or json
+	LogLevel      slog.Level
+	Debug         bool // include panic messages in error responses
+	EnableMetrics bool
+
+	// Storage
+	StoreBackend string // memory or sqlite
+	SQLitePath   string
+	DataFile     string // in-memory snapshot loaded at startup and saved at shutdown
+	SeedData     bool
+	SeedFile     string
+	// NextIDStart is the first product ID. Giving each environment its own
+	// range keeps IDs from colliding when their data is merged. It only raises
+	// the next ID: a store whose IDs are already past it keeps counting.
+	NextIDStart int32
+
+	// Products
+	Currency              string // ISO 4217 code for products that omit one
+	MaxStock              int32  // 0 means unlimited
+	MaxProducts           int    // 0 means unlimited
+	CollapseNameSpaces    bool
+	PreventDuplicateNames bool
+	CacheTTL              time.Duration // 0 disables the GET /products/{productId} cache
+	ReservationTTL        time.Duration
+	IdempotencyTTL        time.Duration
+	HistoryDepth          int // changes kept per product for GET /products/{productId}/history
+}
+
+// DefaultConfig returns the configuration used when no environment variables
+// are set
+func DefaultConfig() Config {
+	return Config{
+		Port:              "8080",
+		AllowedOrigins:    []string{"*"},
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		RequestTimeout:    5 * time.Second,
+		ShutdownTimeout:   defaultShutdownTimeout,
+		RateLimit:         10,
+		MaxBodyBytes:      defaultMaxBodyBytes,
+		MaxQueryBytes:     defaultMaxQueryBytes,
+		MaxQueryParams:    defaultMaxQueryParams,
+		RequestEncodings:  []string{"gzip"},
+		StrictJSON:        true,
+		LogFormat:         "text",
+		LogLevel:          slog.LevelInfo,
+		StoreBackend:      "memory",
+		SeedData:          true,
+		NextIDStart:       1,
+		Currency:          defaultCurrency,
+		ReservationTTL:    defaultReservationTTL,
+		IdempotencyTTL:    defaultIdempotencyTTL,
+		HistoryDepth:      defaultHistoryDepth,
+	}
+}
+
+// LoadConfig reads the configuration from the environment through getenv
+// (normally os.Getenv). Every setting is checked, and all invalid values and
+// conflicting combinations are reported together in the returned error.
+func LoadConfig(getenv func(string) string) (Config, error) {
+	cfg := DefaultConfig()
+	p := &envParser{getenv: getenv}
+
+	if port := getenv("PORT"); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			p.fail("PORT", port, "must be a number between 1 and 65535")
+		} else {
+			cfg.Port = port
+		}
+	}
+	if prefix := strings.Trim(getenv("API_PREFIX"), "/"); prefix != "" {
+		cfg.APIPrefix = "/" + prefix
+	}
+	cfg.AllowedOrigins = parseAllowedOrigins(getenv)
+	cfg.TLSCert, cfg.TLSKey = getenv("TLS_CERT"), getenv("TLS_KEY")
+	cfg.APIKey = getenv("API_KEY")
+	if value := getenv("TRUSTED_PROXIES"); value != "" {
+		if proxies, err := parseTrustedProxies(value); err != nil {
+			p.fail("TRUSTED_PROXIES", value, err.Error())
+		} else {
+			cfg.TrustedProxies = proxies
+		}
+	}
+	p.duration("READ_HEADER_TIMEOUT", &cfg.ReadHeaderTimeout)
+	p.duration("READ_TIMEOUT", &cfg.ReadTimeout)
+	p.duration("WRITE_TIMEOUT", &cfg.WriteTimeout)
+	p.duration("IDLE_TIMEOUT", &cfg.IdleTimeout)
+	p.duration("REQUEST_TIMEOUT", &cfg.RequestTimeout)
+	p.duration("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+
+	if value := getenv("RATE_LIMIT"); value != "" {
+		if rps, err := strconv.ParseFloat(value, 64); err != nil || rps <= 0 || math.IsInf(rps, 0) {
+			p.fail("RATE_LIMIT", value, "must be a positive number")
+		} else {
+			cfg.RateLimit = rps
+		}
+	}
+	if value := getenv("MAX_BODY_BYTES"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err != nil || n < 1 {
+			p.fail("MAX_BODY_BYTES", value, "must be a positive integer")
+		} else {
+			cfg.MaxBodyBytes = n
+		}
+	}
+	p.nonNegativeInt("MAX_QUERY_BYTES", &cfg.MaxQueryBytes)
+	p.nonNegativeInt("MAX_QUERY_PARAMS", &cfg.MaxQueryParams)
+	p.bool("STRICT_JSON", &cfg.StrictJSON)
+	if value := getenv("REQUEST_ENCODINGS"); value != "" {
+		cfg.RequestEncodings = nil
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "identity" {
+				continue
+			}
+			if _, ok := requestDecoders[encoding]; !ok {
+				p.fail("REQUEST_ENCODINGS", value, fmt.Sprintf("%q is not one of gzip, deflate or identity", encoding))
+				continue
+			}
+			cfg.RequestEncodings = append(cfg.RequestEncodings, encoding)
+		}
+	}
+
+	switch format := getenv("LOG_FORMAT"); format {
+	case "":
+	case "text", "json":
+		cfg.LogFormat = format
+	default:
+		p.fail("LOG_FORMAT", format, "must be text or json")
+	}
+	switch value := getenv("LOG_LEVEL"); strings.ToLower(value) {
+	case "", "info":
+	case "debug":
+		cfg.LogLevel = slog.LevelDebug
+	case "warn":
+		cfg.LogLevel = slog.LevelWarn
+	case "error":
+		cfg.LogLevel = slog.LevelError
+	default:
+		p.fail("LOG_LEVEL", value, "must be debug, info, warn or error")
+	}
+	p.bool("DEBUG", &cfg.Debug)
+	p.bool("ENABLE_METRICS", &cfg.EnableMetrics)
+
+	switch backend := getenv("STORE_BACKEND"); backend {
+	case "":
+	case "memory", "sqlite":
+		cfg.StoreBackend = backend
+	default:
+		p.fail("STORE_BACKEND", backend, "must be memory or sqlite")
+	}
+	cfg.SQLitePath = getenv("SQLITE_PATH")
+	cfg.DataFile = getenv("DATA_FILE")
+	p.bool("SEED_DATA", &cfg.SeedData)
+	cfg.SeedFile = getenv("SEED_FILE")
+	if value := getenv("NEXT_ID_START"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 32); err != nil || n < 1 {
+			p.fail("NEXT_ID_START", value, "must be a positive integer")
+		} else {
+			cfg.NextIDStart = int32(n)
+		}
+	}
+
+	if value := getenv("CURRENCY"); value != "" {
+		if !validCurrency(value) {
+			p.fail("CURRENCY", value, "must be an ISO 4217 code such as USD")
+		} else {
+			cfg.Currency = value
+		}
+	}
+	if value := getenv("MAX_STOCK"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 32); err != nil || n < 0 {
+			p.fail("MAX_STOCK", value, "must be a non-negative integer")
+		} else {
+			cfg.MaxStock = int32(n)
+		}
+	}
+	p.nonNegativeInt("MAX_PRODUCTS", &cfg.MaxProducts)
+	p.bool("COLLAPSE_NAME_SPACES", &cfg.CollapseNameSpaces)
+	p.bool("PREVENT_DUPLICATE_NAMES", &cfg.PreventDuplicateNames)
+	if value := getenv("CACHE_TTL"); value != "" && value != "0" {
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			p.fail("CACHE_TTL", value, "must be a duration such as 30s, or 0 to disable")
+		} else {
+			cfg.CacheTTL = d
+		}
+	}
+	p.duration("RESERVATION_TTL", &cfg.ReservationTTL)
+	p.duration("IDEMPOTENCY_TTL", &cfg.IdempotencyTTL)
+	if value := getenv("HISTORY_DEPTH"); value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 1 {
+			p.fail("HISTORY_DEPTH", value, "must be a positive integer")
+		} else {
+			cfg.HistoryDepth = n
+		}
+	}
+
+	p.errs = append(p.errs, cfg.validate()...)
+	return cfg, errors.Join(p.errs...)
+}
+
+// validate checks settings that depend on each other or on the filesystem
+func (cfg *Config) validate() []error {
+	var errs []error
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("TLS_CERT and TLS_KEY must be set together"))
+	} else if cfg.TLSCert != "" {
+		statErrs := 0
+		for _, file := range []struct{ name, path string }{{"TLS_CERT", cfg.TLSCert}, {"TLS_KEY", cfg.TLSKey}} {
+			if _, err := os.Stat(file.path); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %q: %v", file.name, file.path, err))
+				statErrs++
+			}
+		}
+		if statErrs == 0 {
+			if _, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey); err != nil {
+				errs = append(errs, fmt.Errorf("invalid TLS_CERT/TLS_KEY pair: %v", err))
+			}
+		}
+	}
+
+	switch cfg.StoreBackend {
+	case "sqlite":
+		if cfg.SQLitePath == "" {
+			cfg.SQLitePath = "products.db"
+		}
+		if cfg.DataFile != "" {
+			errs = append(errs, errors.New("DATA_FILE is only supported with STORE_BACKEND=memory; the sqlite backend persists on its own"))
+		}
+	case "memory":
+		if cfg.SQLitePath != "" {
+			errs = append(errs, errors.New("SQLITE_PATH is set but STORE_BACKEND is not sqlite"))
+		}
+	}
+	if cfg.SeedFile != "" && !cfg.SeedData {
+		errs = append(errs, errors.New("SEED_FILE is set but SEED_DATA is false"))
+	}
+	// A handler timing out after the write deadline could never send its 503
+	if cfg.RequestTimeout >= cfg.WriteTimeout {
+		errs = append(errs, fmt.Errorf("REQUEST_TIMEOUT (%s) must be shorter than WRITE_TIMEOUT (%s)", cfg.RequestTimeout, cfg.WriteTimeout))
+	}
+	return errs
+}
+
+// LogValue logs the effective configuration as a single group, with the API
+// key redacted
+func (cfg Config) LogValue() slog.Value {
+	apiKey := ""
+	if cfg.APIKey != "" {
+		apiKey = "[REDACTED]"
+	}
+	return slog.GroupValue(
+		slog.String("port", cfg.Port),
+		slog.String("api_prefix", cfg.APIPrefix),
+		slog.Any("allowed_origins", cfg.AllowedOrigins),
+		slog.Bool("tls", cfg.TLSCert != ""),
+		slog.String("api_key", apiKey),
+		slog.Any("trusted_proxies", cfg.TrustedProxies),
+		slog.Duration("read_header_timeout", cfg.ReadHeaderTimeout),
+		slog.Duration("read_timeout", cfg.ReadTimeout),
+		slog.Duration("write_timeout", cfg.WriteTimeout),
+		slog.Duration("idle_timeout", cfg.IdleTimeout),
+		slog.Duration("request_timeout", cfg.RequestTimeout),
+		slog.Duration("shutdown_timeout", cfg.ShutdownTimeout),
+		slog.Float64("rate_limit", cfg.RateLimit),
+		slog.Int64("max_body_bytes", cfg.MaxBodyBytes),
+		slog.Int("max_query_bytes", cfg.MaxQueryBytes),
+		slog.Int("max_query_params", cfg.MaxQueryParams),
+		slog.Any("request_encodings", cfg.RequestEncodings),
+		slog.Bool("strict_json", cfg.StrictJSON),
+		slog.String("log_format", cfg.LogFormat),
+		slog.String("log_level", cfg.LogLevel.String()),
+		slog.Bool("debug", cfg.Debug),
+		slog.Bool("metrics", cfg.EnableMetrics),
+		slog.String("store_backend", cfg.StoreBackend),
+		slog.String("sqlite_path", cfg.SQLitePath),
+		slog.String("data_file", cfg.DataFile),
+		slog.Bool("seed_data", cfg.SeedData),
+		slog.String("seed_file", cfg.SeedFile),
+		slog.Int("next_id_start", int(cfg.NextIDStart)),
+		slog.String("currency", cfg.Currency),
+		slog.Int("max_stock", int(cfg.MaxStock)),
+		slog.Int("max_products", cfg.MaxProducts),
+		slog.Bool("collapse_name_spaces", cfg.CollapseNameSpaces),
+		slog.Bool("prevent_duplicate_names", cfg.PreventDuplicateNames),
+		slog.Duration("cache_ttl", cfg.CacheTTL),
+		slog.Duration("reservation_ttl", cfg.ReservationTTL),
+		slog.Duration("idempotency_ttl", cfg.IdempotencyTTL),
+		slog.Int("history_depth", cfg.HistoryDepth),
+	)
+}
+
+// parseAllowedOrigins reads the comma-separated CORS origin allowlist from
+// ALLOWED_ORIGINS, falling back to the single ALLOWED_ORIGIN. With neither
+// set, any origin is allowed.
+func parseAllowedOrigins(getenv func(string) string) []string {
+	value := getenv("ALLOWED_ORIGINS")
+	if value == "" {
+		value = getenv("ALLOWED_ORIGIN")
+	}
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return []string{"*"}
+	}
+	return origins
+}
+
+// envParser reads typed values from the environment, collecting an error for
+// each invalid one instead of stopping at the first. A setter leaves its
+// destination (the default) unchanged when the variable is unset or invalid.
+type envParser struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (p *envParser) fail(name, value, reason string) {
+	p.errs = append(p.errs, fmt.Errorf("invalid %s %q: %s", name, value, reason))
+}
+
+// bool reads true or false
+func (p *envParser) bool(name string, dst *bool) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(name, value, "must be true or false")
+		return
+	}
+	*dst = b
+}
+
+// nonNegativeInt reads an integer of at least 0
+func (p *envParser) nonNegativeInt(name string, dst *int) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		p.fail(name, value, "must be a non-negative integer")
+		return
+	}
+	*dst = n
+}
+
+// duration reads a positive Go duration such as "5s"
+func (p *envParser) duration(name string, dst *time.Duration) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		p.fail(name, value, "must be a positive duration such as 5s")
+		return
+	}
+	*dst = d
+}
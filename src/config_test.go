@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envFrom returns a getenv over env
+func envFrom(env map[string]string) func(string) string {
+	return func(name string) string { return env[name] }
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig(envFrom(nil))
+	if err != nil {
+		t.Fatalf("empty environment: %v", err)
+	}
+	if want := DefaultConfig(); !reflect.DeepEqual(cfg, want) {
+		t.Errorf("config = %+v, want the defaults %+v", cfg, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig(envFrom(map[string]string{
+		"PORT":              "9090",
+		"API_PREFIX":        "/api/",
+		"API_KEY":           "secret",
+		"REQUEST_TIMEOUT":   "2s",
+		"RATE_LIMIT":        "2.5",
+		"REQUEST_ENCODINGS": "gzip, identity, DEFLATE",
+		"LOG_LEVEL":         "WARN",
+		"STORE_BACKEND":     "sqlite",
+		"CACHE_TTL":         "30s",
+		"MAX_PRODUCTS":      "100",
+		"TRUSTED_PROXIES":   "10.0.0.0/8",
+	}))
+	if err != nil {
+		t.Fatalf("valid environment: %v", err)
+	}
+	if cfg.Port != "9090" || cfg.APIPrefix != "/api" || cfg.APIKey != "secret" || cfg.RequestTimeout != 2*time.Second ||
+		cfg.RateLimit != 2.5 || !reflect.DeepEqual(cfg.RequestEncodings, []string{"gzip", "deflate"}) ||
+		cfg.LogLevel != slog.LevelWarn || cfg.CacheTTL != 30*time.Second || cfg.MaxProducts != 100 || len(cfg.TrustedProxies) != 1 {
+		t.Errorf("config = %+v", cfg)
+	}
+	// The sqlite backend defaults its database path
+	if cfg.StoreBackend != "sqlite" || cfg.SQLitePath != "products.db" {
+		t.Errorf("store = %s at %q, want sqlite at products.db", cfg.StoreBackend, cfg.SQLitePath)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string // substrings of the aggregated error
+	}{
+		{"bad port", map[string]string{"PORT": "http"}, []string{"PORT"}},
+		{"port out of range", map[string]string{"PORT": "70000"}, []string{"PORT"}},
+		{"bad duration", map[string]string{"READ_TIMEOUT": "soon"}, []string{"READ_TIMEOUT"}},
+		{"zero rate limit", map[string]string{"RATE_LIMIT": "0"}, []string{"RATE_LIMIT"}},
+		{"unknown log format", map[string]string{"LOG_FORMAT": "xml"}, []string{"LOG_FORMAT"}},
+		{"unknown backend", map[string]string{"STORE_BACKEND": "postgres"}, []string{"STORE_BACKEND"}},
+		{"unknown currency", map[string]string{"CURRENCY": "XYZ"}, []string{"CURRENCY"}},
+		{"unknown encoding", map[string]string{"REQUEST_ENCODINGS": "gzip,br"}, []string{"REQUEST_ENCODINGS"}},
+		{"bad proxy", map[string]string{"TRUSTED_PROXIES": "not-a-cidr"}, []string{"TRUSTED_PROXIES"}},
+		{"TLS cert without key", map[string]string{"TLS_CERT": "cert.pem"}, []string{"TLS_CERT and TLS_KEY must be set together"}},
+		{"missing TLS files", map[string]string{"TLS_CERT": filepath.Join(dir, "cert.pem"), "TLS_KEY": filepath.Join(dir, "key.pem")}, []string{"invalid TLS_CERT", "invalid TLS_KEY"}},
+		{"data file with sqlite", map[string]string{"STORE_BACKEND": "sqlite", "DATA_FILE": "products.json"}, []string{"DATA_FILE"}},
+		{"sqlite path with memory", map[string]string{"SQLITE_PATH": "products.db"}, []string{"SQLITE_PATH"}},
+		{"seed file without seeding", map[string]string{"SEED_DATA": "false", "SEED_FILE": "seed.json"}, []string{"SEED_FILE"}},
+		{"request timeout after write timeout", map[string]string{"REQUEST_TIMEOUT": "20s"}, []string{"REQUEST_TIMEOUT (20s) must be shorter than WRITE_TIMEOUT (15s)"}},
+		{"several problems", map[string]string{"PORT": "0", "LOG_LEVEL": "loud", "MAX_PRODUCTS": "-1"}, []string{"PORT", "LOG_LEVEL", "MAX_PRODUCTS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(envFrom(tt.env))
+			if err == nil {
+				t.Fatal("invalid environment accepted")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigLogValue(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.APIKey = "secret"
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("Configuration", "config", cfg)
+
+	logged := buf.String()
+	if strings.Contains(logged, "secret") {
+		t.Errorf("API key leaked into the log: %s", logged)
+	}
+	if strings.Count(logged, "\n") != 1 || !strings.Contains(logged, "config.api_key=[REDACTED]") || !strings.Contains(logged, "config.port=8080") {
+		t.Errorf("configuration not logged as one line with the key redacted: %s", logged)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// csvHeader is the column layout used for CSV export and required for import
+var csvHeader = []string{"id", "name", "description", "price", "currency", "stock", "category", "categories", "imageUrl"}
+
+// csvTagSeparator joins a product's Categories tags within the categories column
+const csvTagSeparator = "|"
+
+// acceptsCSV reports whether the request explicitly asks for text/csv
+func acceptsCSV(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "text/csv" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeProductsCSV streams products as a CSV attachment with a header row
+func writeProductsCSV(w http.ResponseWriter, products []*Product) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="products.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		slog.Error("Error writing CSV header", "error", err)
+		return
+	}
+	for _, p := range products {
+		record := []string{
+			strconv.FormatInt(int64(p.ID), 10),
+			p.Name,
+			p.Description,
+			p.Price.Fixed(),
+			p.Currency,
+			strconv.FormatInt(int64(p.Stock), 10),
+			p.Category,
+			strings.Join(p.Categories, csvTagSeparator),
+			p.ImageURL,
+		}
+		if err := cw.Write(record); err != nil {
+			slog.Error("Error writing CSV record", "id", p.ID, "error", err)
+			return
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		slog.Error("Error flushing CSV output", "error", err)
+	}
+}
+
+// ImportError reports why a single CSV line could not be imported
+type ImportError struct {
+	Line    int    `json:"line" xml:"line"`
+	Message string `json:"message" xml:"message"`
+}
+
+// ImportSummary is the response body of POST /products/import
+type ImportSummary struct {
+	XMLName xml.Name      `json:"-" xml:"import"`
+	Created int           `json:"created" xml:"created"`
+	Errors  []ImportError `json:"errors" xml:"errors>error"`
+}
+
+// HandleImportCSV handles POST /products/import. Rows that fail validation are
+// reported by line number and skipped; with ?strict=true the first failure
+// aborts the import and nothing is created.
+func (s *Server) HandleImportCSV(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/csv" {
+		writeErrorResponse(w, r, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, "Content-Type must be text/csv")
+		return
+	}
+	strict := r.URL.Query().Get("strict") == "true"
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = len(csvHeader)
+
+	header, err := reader.Read()
+	if err != nil {
+		writeCSVReadError(w, r, err)
+		return
+	}
+	if !equalFoldAll(header, csvHeader) {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, "Invalid CSV header: expected "+strings.Join(csvHeader, ","))
+		return
+	}
+
+	// Read and validate every row before taking the create lock, so a slow
+	// upload doesn't hold off other creates
+	type csvRow struct {
+		line    int
+		product *Product
+	}
+	summary := ImportSummary{Errors: []ImportError{}}
+	var rows []csvRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		var product *Product
+		if errors.Is(err, csv.ErrFieldCount) {
+			err = fmt.Errorf("expected %d fields, got %d", len(csvHeader), len(record))
+		} else if err != nil {
+			writeCSVReadError(w, r, err)
+			return
+		} else {
+			product, err = s.productFromCSV(record)
+		}
+		if err != nil {
+			if strict {
+				writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, fmt.Sprintf("Invalid CSV line %d: %v", line, err))
+				return
+			}
+			summary.Errors = append(summary.Errors, ImportError{Line: line, Message: err.Error()})
+			continue
+		}
+		rows = append(rows, csvRow{line: line, product: product})
+	}
+
+	// Hold off other creates only while rows are checked for duplicate names
+	// and MAX_PRODUCTS and then stored; rows beyond the limit are reported
+	// like invalid ones
+	unlock := s.lockCreates()
+	defer unlock()
+	var products []*Product
+	seenNames := make(map[string]bool)
+	capacity := s.productCapacity()
+	for _, row := range rows {
+		var err error
+		if msg := s.duplicateName(row.product.Name, seenNames); msg != "" {
+			err = errors.New(msg)
+		} else if capacity >= 0 && len(products) >= capacity {
+			err = fmt.Errorf("product limit of %d reached", s.maxProducts)
+		}
+		if err != nil {
+			if strict {
+				writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, fmt.Sprintf("Invalid CSV line %d: %v", row.line, err))
+				return
+			}
+			summary.Errors = append(summary.Errors, ImportError{Line: row.line, Message: err.Error()})
+			continue
+		}
+		products = append(products, row.product)
+	}
+	// Report errors from both passes in line order
+	sort.SliceStable(summary.Errors, func(i, j int) bool {
+		return summary.Errors[i].Line < summary.Errors[j].Line
+	})
+
+	// Create all valid rows under a single store lock acquisition
+	created, err := s.store.CreateProducts(products)
+	if err != nil {
+		writeStoreError(w, r, 0, err)
+		return
+	}
+	for _, product := range created {
+		s.recordAudit(r, AuditCreate, product.ID)
+		summary.Created++
+	}
+	writeResponse(w, r, http.StatusOK, summary)
+}
+
+// productFromCSV converts a CSV record into a validated product; the id
+// column is ignored since the store assigns IDs. An empty currency takes the
+// server's default, as it does in JSON, and category tags are split on
+// csvTagSeparator.
+func (s *Server) productFromCSV(record []string) (*Product, error) {
+	price, err := ParseCents(record[3])
+	if errors.Is(err, ErrCentsPrecision) {
+		return nil, errors.New("price must have at most two decimal places")
+	} else if err != nil {
+		return nil, errors.New("price must be a number")
+	}
+	stock, err := strconv.ParseInt(record[5], 10, 32)
+	if err != nil {
+		return nil, errors.New("stock must be an integer")
+	}
+	product := &Product{
+		Name:        record[1],
+		Description: record[2],
+		Price:       price,
+		Currency:    record[4],
+		Stock:       int32(stock),
+		Category:    record[6],
+		ImageURL:    record[8],
+	}
+	if record[7] != "" {
+		product.Categories = strings.Split(record[7], csvTagSeparator)
+	}
+	if fieldErrors := s.validate(product); len(fieldErrors) > 0 {
+		messages := make([]string, len(fieldErrors))
+		for i, fe := range fieldErrors {
+			messages[i] = fe.Message
+		}
+		return nil, errors.New(strings.Join(messages, "; "))
+	}
+	return product, nil
+}
+
+// writeCSVReadError maps an error reading the CSV body onto an error response
+func writeCSVReadError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge, fmt.Sprintf("Request body too large: limit is %d bytes", maxBytesErr.Limit))
+	case err == io.EOF:
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, "Request body is empty: a CSV header row is required")
+	default:
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, fmt.Sprintf("Invalid CSV: %v", err))
+	}
+}
+
+// equalFoldAll reports whether a and b hold the same strings, ignoring case
+func equalFoldAll(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(strings.TrimSpace(a[i]), b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportCSV returns the records of the CSV export of h, header row first
+func exportCSV(t *testing.T, h http.Handler) [][]string {
+	t.Helper()
+	rec := serve(h, "GET", "/v1/products.csv", "")
+	assertStatus(t, rec, http.StatusOK)
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing export: %v", err)
+	}
+	return records
+}
+
+func TestCSVExportCurrency(t *testing.T) {
+	server, h := newTestServer(t)
+	createProduct(t, server.store, &Product{Name: "Croissant", Price: 250, Currency: "EUR", Stock: 3})
+
+	records := exportCSV(t, h)
+	if got := strings.Join(records[0], ","); got != strings.Join(csvHeader, ",") {
+		t.Fatalf("header = %s, want %s", got, strings.Join(csvHeader, ","))
+	}
+	if got := strings.Join(records[1], ","); got != "1,Laptop,High-performance laptop,999.99,USD,10,Electronics,," {
+		t.Errorf("laptop row = %s", got)
+	}
+	if got := strings.Join(records[4], ","); got != "4,Croissant,,2.50,EUR,3,,," {
+		t.Errorf("croissant row = %s", got)
+	}
+}
+
+func TestCSVImportCurrency(t *testing.T) {
+	tests := []struct {
+		name         string
+		currency     string
+		wantCurrency string // "" when the row is rejected
+	}{
+		{"valid", "EUR", "EUR"},
+		{"defaulted", "", "USD"},
+		{"unknown code", "XYZ", ""},
+		{"lower case", "eur", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			body := strings.Join(csvHeader, ",") + "\n,Croissant,Buttery,2.50," + tt.currency + ",3,Bakery,,\n"
+			rec := serve(h, "POST", "/v1/products/import", body, "Content-Type", "text/csv")
+			assertStatus(t, rec, http.StatusOK)
+			summary := decodeBody[ImportSummary](t, rec)
+			if tt.wantCurrency == "" {
+				if summary.Created != 0 || len(summary.Errors) != 1 || !strings.Contains(summary.Errors[0].Message, "currency") {
+					t.Errorf("summary = %+v, want the row rejected for its currency", summary)
+				}
+				return
+			}
+			if summary.Created != 1 || len(summary.Errors) != 0 {
+				t.Fatalf("summary = %+v, want one product created", summary)
+			}
+			if product := getProduct(t, h, "4"); product.Currency != tt.wantCurrency || product.Price != 250 {
+				t.Errorf("imported product = %+v, want %s 2.50", product, tt.wantCurrency)
+			}
+		})
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	source, h := newTestServer(t)
+	createProduct(t, source.store, &Product{Name: "Croissant", Description: "Buttery, flaky", Price: 250, Currency: "EUR", Stock: 3, Category: "Bakery", Categories: []string{"Breakfast", "French"}})
+	exported := serve(h, "GET", "/v1/products.csv", "").Body.String()
+
+	_, target := newTestServer(t, func(cfg *Config) { cfg.SeedData = false })
+	rec := serve(target, "POST", "/v1/products/import?strict=true", exported, "Content-Type", "text/csv")
+	assertStatus(t, rec, http.StatusOK)
+	if summary := decodeBody[ImportSummary](t, rec); summary.Created != 4 {
+		t.Fatalf("created = %d, want 4", summary.Created)
+	}
+	if got := serve(target, "GET", "/v1/products.csv", "").Body.String(); got != exported {
+		t.Errorf("re-export differs:\n%s\nwant:\n%s", got, exported)
+	}
+}
+
+func TestCSVCategoryTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     string
+		wantTags []string // nil when the row is rejected or has no tags
+		wantErr  string
+	}{
+		{"several tags", "Breakfast|French", []string{"Breakfast", "French"}, ""},
+		{"one tag", "Breakfast", []string{"Breakfast"}, ""},
+		{"no tags", "", nil, ""},
+		{"padded tags", " Breakfast | French ", []string{"Breakfast", "French"}, ""},
+		{"empty tag", "Breakfast||French", nil, "categories[1] must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			body := strings.Join(csvHeader, ",") + "\n,Croissant,Buttery,2.50,EUR,3,Bakery," + tt.tags + ",\n"
+			rec := serve(h, "POST", "/v1/products/import", body, "Content-Type", "text/csv")
+			assertStatus(t, rec, http.StatusOK)
+			summary := decodeBody[ImportSummary](t, rec)
+			if tt.wantErr != "" {
+				if summary.Created != 0 || len(summary.Errors) != 1 || summary.Errors[0].Message != tt.wantErr {
+					t.Errorf("summary = %+v, want the row rejected with %q", summary, tt.wantErr)
+				}
+				return
+			}
+			if summary.Created != 1 {
+				t.Fatalf("summary = %+v, want one product created", summary)
+			}
+			if product := getProduct(t, h, "4"); !slices.Equal(product.Categories, tt.wantTags) {
+				t.Errorf("categories = %q, want %q", product.Categories, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestCSVImportLimits(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) {
+		cfg.MaxProducts = 5
+		cfg.PreventDuplicateNames = true
+	})
+	rows := []string{
+		",Laptop,,1.00,USD,1,,,",
+		",Desk,,not a price,USD,1,,,",
+		",Desk,,1.00,USD,1,,,",
+		",Chair,,1.00,USD,1,,,",
+		",Lamp,,1.00,USD,1,,,",
+	}
+	body := strings.Join(csvHeader, ",") + "\n" + strings.Join(rows, "\n") + "\n"
+	rec := serve(h, "POST", "/v1/products/import", body, "Content-Type", "text/csv")
+	assertStatus(t, rec, http.StatusOK)
+
+	// Parse errors and duplicate or over-limit rows are reported together, in line order
+	summary := decodeBody[ImportSummary](t, rec)
+	var lines []int
+	for _, e := range summary.Errors {
+		lines = append(lines, e.Line)
+	}
+	if summary.Created != 2 || !slices.Equal(lines, []int{2, 3, 6}) {
+		t.Errorf("summary = %+v, want 2 created and errors on lines 2, 3 and 6", summary)
+	}
+}
+
+func TestCSVImportDoesNotBlockCreates(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.MaxProducts = 100 })
+	body, upload := io.Pipe()
+	defer upload.Close()
+	req := httptest.NewRequest("POST", "/v1/products/import", body)
+	req.Header.Set("Content-Type", "text/csv")
+	imported := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		imported <- rec
+	}()
+	// The second write returns only once the handler reads past the header
+	for _, chunk := range []string{strings.Join(csvHeader, ",") + "\n", ",Desk,,1.00,USD,1,,,\n"} {
+		if _, err := io.WriteString(upload, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A create must go through while the upload is still streaming
+	created := make(chan *httptest.ResponseRecorder)
+	go func() { created <- serve(h, "POST", "/v1/products", `{"name":"Chair","price":1,"stock":1}`) }()
+	select {
+	case rec := <-created:
+		assertStatus(t, rec, http.StatusCreated)
+	case <-time.After(5 * time.Second):
+		t.Fatal("create blocked behind an unfinished CSV upload")
+	}
+
+	upload.Close()
+	rec := <-imported
+	assertStatus(t, rec, http.StatusOK)
+	if summary := decodeBody[ImportSummary](t, rec); summary.Created != 1 {
+		t.Errorf("summary = %+v, want 1 created", summary)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Product event types, matching the audit operations plus a store reset or
+// import, after which subscribers should refetch everything
+const (
+	EventCreate  = "create"
+	EventUpdate  = "update"
+	EventDelete  = "delete"
+	EventRestore = "restore"
+	EventReset   = "reset"
+	EventImport  = "import"
+)
+
+const (
+	// eventBufferSize is how many events a subscriber may fall behind by
+	// before further events are dropped for it
+	eventBufferSize = 64
+	// eventKeepaliveInterval is how often an idle stream sends a comment so
+	// proxies don't close it
+	eventKeepaliveInterval = 15 * time.Second
+	// routeProductEvents names the event stream route, which is exempt from
+	// the request timeout
+	routeProductEvents = "productEvents"
+)
+
+// ProductEvent describes a single change to the store
+type ProductEvent struct {
+	Type    string    `json:"type"`
+	ID      int32     `json:"id,omitempty"`
+	Product *Product  `json:"product,omitempty"` // state after the change; absent for delete, reset and import
+	Time    time.Time `json:"time"`
+	seq     uint64
+}
+
+// EventBroker fans product events out to subscribers. Publishing never
+// blocks: a subscriber whose buffer is full misses the event.
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan ProductEvent]struct{}
+	seq         uint64
+	closed      bool
+}
+
+// NewEventBroker creates a broker with no subscribers
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[chan ProductEvent]struct{})}
+}
+
+// Subscribe returns a channel receiving every event published from now on.
+// The channel is closed by Unsubscribe or Close.
+func (b *EventBroker) Subscribe() chan ProductEvent {
+	ch := make(chan ProductEvent, eventBufferSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe stops delivery to ch and closes it
+func (b *EventBroker) Unsubscribe(ch chan ProductEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.subscribers[ch]; exists {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish delivers event to every subscriber with room for it
+func (b *EventBroker) Publish(event ProductEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	event.seq = b.seq
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			slog.Debug("Dropped product event for slow subscriber", "type", event.Type, "id", event.ID)
+		}
+	}
+}
+
+// Close ends every subscription, letting open streams finish during shutdown
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// observedStore wraps a Store and reports every successful write to its
+// listeners. Reads pass straight through to the embedded Store; any new write
+// method added to Store must be overridden here too.
+type observedStore struct {
+	Store
+	listeners []func(ProductEvent)
+}
+
+// notify sends an event of type typ for id to every listener, carrying
+// product as the write left it, or nil when it is gone. Taking the product
+// from the write rather than re-reading it keeps a concurrent write from
+// leaking into this event.
+func (s *observedStore) notify(typ string, id int32, product *Product) {
+	event := ProductEvent{Type: typ, ID: id, Product: product, Time: time.Now().UTC()}
+	for _, listener := range s.listeners {
+		listener(event)
+	}
+}
+
+func (s *observedStore) AddOrUpdateProduct(id int32, product *Product) (*Product, error) {
+	updated, err := s.Store.AddOrUpdateProduct(id, product)
+	if err == nil {
+		s.notify(EventUpdate, id, updated)
+	}
+	return updated, err
+}
+
+func (s *observedStore) UpdateProduct(id int32, product *Product, expectedVersion int) (*Product, error) {
+	updated, err := s.Store.UpdateProduct(id, product, expectedVersion)
+	if err == nil {
+		s.notify(EventUpdate, id, updated)
+	}
+	return updated, err
+}
+
+// UpdateProducts reports each applied update with its product, which the
+// store fills in with the stored ID, version and timestamps
+func (s *observedStore) UpdateProducts(updates []ProductUpdate) []error {
+	errs := s.Store.UpdateProducts(updates)
+	for i, err := range errs {
+		if err == nil {
+			s.notify(EventUpdate, updates[i].ID, updates[i].Product)
+		}
+	}
+	return errs
+}
+
+func (s *observedStore) CreateProduct(product *Product) (*Product, error) {
+	created, err := s.Store.CreateProduct(product)
+	if err == nil {
+		s.notify(EventCreate, created.ID, created)
+	}
+	return created, err
+}
+
+func (s *observedStore) CreateProducts(products []*Product) ([]*Product, error) {
+	created, err := s.Store.CreateProducts(products)
+	for _, product := range created {
+		s.notify(EventCreate, product.ID, product)
+	}
+	return created, err
+}
+
+func (s *observedStore) DecrementStock(id int32, qty int32) (*Product, error) {
+	product, err := s.Store.DecrementStock(id, qty)
+	if err == nil {
+		s.notify(EventUpdate, id, product)
+	}
+	return product, err
+}
+
+func (s *observedStore) IncrementStock(id int32, qty int32) (*Product, error) {
+	product, err := s.Store.IncrementStock(id, qty)
+	if err == nil {
+		s.notify(EventUpdate, id, product)
+	}
+	return product, err
+}
+
+func (s *observedStore) AdjustStock(id int32, delta int32, limit int32) (*Product, error) {
+	product, err := s.Store.AdjustStock(id, delta, limit)
+	if err == nil {
+		s.notify(EventUpdate, id, product)
+	}
+	return product, err
+}
+
+func (s *observedStore) DeleteProduct(id int32) error {
+	err := s.Store.DeleteProduct(id)
+	if err == nil {
+		s.notify(EventDelete, id, nil)
+	}
+	return err
+}
+
+func (s *observedStore) RestoreProduct(id int32) (*Product, error) {
+	product, err := s.Store.RestoreProduct(id)
+	if err == nil {
+		s.notify(EventRestore, id, product)
+	}
+	return product, err
+}
+
+func (s *observedStore) Reset() error {
+	err := s.Store.Reset()
+	if err == nil {
+		s.notify(EventReset, 0, nil)
+	}
+	return err
+}
+
+func (s *observedStore) Import(snapshot storeSnapshot, replace bool) error {
+	err := s.Store.Import(snapshot, replace)
+	if err == nil {
+		s.notify(EventImport, 0, nil)
+	}
+	return err
+}
+
+// Ping checks the wrapped store when it supports health checks
+func (s *observedStore) Ping(ctx context.Context) error {
+	if pinger, ok := s.Store.(StorePinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// observeStore registers listener for every successful store write, wrapping
+// the server's store (and the reservation manager's) on first use. It must be
+// called before the server starts handling requests.
+func (s *Server) observeStore(listener func(ProductEvent)) {
+	observed, ok := s.store.(*observedStore)
+	if !ok {
+		observed = &observedStore{Store: s.store}
+		s.store = observed
+		s.reservations.store = observed
+	}
+	observed.listeners = append(observed.listeners, listener)
+}
+
+// HandleProductEvents handles GET /products/events, streaming every store
+// change as a server-sent event until the client disconnects or the server
+// shuts down
+func (s *Server) HandleProductEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// A stream outlives the server's WriteTimeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Warn("Error clearing write deadline for event stream", "error", err)
+	}
+
+	events := s.events.Subscribe()
+	defer s.events.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.Error("Event stream not supported by response writer", "error", err)
+		return
+	}
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("Error encoding product event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.seq, event.Type, data); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscriberCount returns how many streams are subscribed to b
+func subscriberCount(b *EventBroker) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+func TestEventBrokerSlowSubscriber(t *testing.T) {
+	broker := NewEventBroker()
+	slow := broker.Subscribe()
+
+	// Publishing must not block on a subscriber that never reads
+	done := make(chan struct{})
+	go func() {
+		for i := range eventBufferSize + 10 {
+			broker.Publish(ProductEvent{Type: EventUpdate, ID: int32(i + 1)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+	if len(slow) != eventBufferSize {
+		t.Errorf("buffered events = %d, want %d", len(slow), eventBufferSize)
+	}
+
+	broker.Unsubscribe(slow)
+	for range slow {
+	}
+	if n := subscriberCount(broker); n != 0 {
+		t.Errorf("subscribers after Unsubscribe = %d, want 0", n)
+	}
+}
+
+// unreadableStore is a ProductStore whose reads all miss, so anything a
+// wrapper reports about a write must come from the write itself
+type unreadableStore struct {
+	*ProductStore
+}
+
+func (unreadableStore) GetProduct(int32) (*Product, error) {
+	return nil, ErrProductNotFound
+}
+
+func TestObservedStoreEventProducts(t *testing.T) {
+	var events []ProductEvent
+	store := &observedStore{
+		Store:     unreadableStore{NewProductStore()},
+		listeners: []func(ProductEvent){func(e ProductEvent) { events = append(events, e) }},
+	}
+
+	created, _ := store.CreateProduct(&Product{Name: "Widget", Price: 1, Currency: "USD", Stock: 5})
+	updated, _ := store.UpdateProduct(created.ID, &Product{Name: "Gadget", Price: 2, Currency: "USD", Stock: 5}, 0)
+	added, _ := store.AddOrUpdateProduct(created.ID, &Product{Name: "Gizmo", Price: 3, Currency: "USD", Stock: 5})
+	decremented, _ := store.DecrementStock(created.ID, 1)
+	incremented, _ := store.IncrementStock(created.ID, 2)
+	adjusted, _ := store.AdjustStock(created.ID, -3, 0)
+	store.DeleteProduct(created.ID)
+	restored, _ := store.RestoreProduct(created.ID)
+
+	want := []*Product{created, updated, added, decremented, incremented, adjusted, nil, restored}
+	if len(events) != len(want) {
+		t.Fatalf("events = %d, want %d", len(events), len(want))
+	}
+	for i, event := range events {
+		if event.Product != want[i] {
+			t.Errorf("%s event %d product = %+v, want %+v", event.Type, i, event.Product, want[i])
+		}
+	}
+	if last := events[len(events)-1].Product; last == nil || last.Name != "Gizmo" || last.Stock != 3 || last.Version != 8 {
+		t.Errorf("restore event product = %+v, want Gizmo with stock 3 at version 8", last)
+	}
+}
+
+func TestHandleProductEvents(t *testing.T) {
+	server, h := newTestServer(t)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/v1/products/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("opening stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream response = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// The handler subscribes before sending headers, so these writes are seen
+	assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Widget","price":1,"stock":1}`), http.StatusCreated)
+	assertStatus(t, serve(h, "POST", "/v1/products/4/purchase", `{"quantity":1}`), http.StatusOK)
+	assertStatus(t, serve(h, "DELETE", "/v1/products/4", ""), http.StatusNoContent)
+
+	events := make(chan ProductEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event ProductEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Errorf("decoding event %q: %v", data, err)
+				return
+			}
+			events <- event
+		}
+	}()
+
+	want := []struct {
+		typ   string
+		stock int32 // -1 when the event carries no product
+	}{
+		{EventCreate, 1},
+		{EventUpdate, 0},
+		{EventDelete, -1},
+	}
+	for _, w := range want {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("stream ended before the %s event", w.typ)
+			}
+			if event.Type != w.typ || event.ID != 4 {
+				t.Fatalf("event = %s for %d, want %s for 4", event.Type, event.ID, w.typ)
+			}
+			if w.stock < 0 {
+				if event.Product != nil {
+					t.Errorf("%s event carries product %+v", event.Type, event.Product)
+				}
+			} else if event.Product == nil || event.Product.Stock != w.stock {
+				t.Errorf("%s event product = %+v, want stock %d", event.Type, event.Product, w.stock)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %s event", w.typ)
+		}
+	}
+
+	// Disconnecting unsubscribes the stream
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriberCount(server.events) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream still subscribed after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
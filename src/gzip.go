@@ -0,0 +1,200 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// gzipMinSize is the smallest response body worth compressing; anything
+// shorter is sent as-is since gzip overhead would outweigh the savings
+const gzipMinSize = 1024
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// the body is large enough to compress, then streams through a gzip.Writer
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	buf         []byte
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+// WriteHeader records the status code; it is sent once compression is decided
+func (g *gzipResponseWriter) WriteHeader(statusCode int) {
+	if g.status == 0 {
+		g.status = statusCode
+	}
+}
+
+// Write buffers small bodies and compresses once the threshold is crossed
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	if g.passthrough {
+		return g.ResponseWriter.Write(b)
+	}
+
+	g.buf = append(g.buf, b...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start commits to compressing (or passing through already-encoded bodies)
+// and flushes the buffered bytes
+func (g *gzipResponseWriter) start() error {
+	h := g.Header()
+	// Event streams are sent uncompressed so each event reaches the client as
+	// soon as it is flushed
+	if h.Get("Content-Encoding") != "" || strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") {
+		g.passthrough = true
+		g.ResponseWriter.WriteHeader(g.status)
+		_, err := g.ResponseWriter.Write(g.buf)
+		g.buf = nil
+		return err
+	}
+
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length") // the compressed length is not known up front
+	g.ResponseWriter.WriteHeader(g.status)
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// Flush implements http.Flusher; see FlushError
+func (g *gzipResponseWriter) Flush() {
+	g.FlushError()
+}
+
+// FlushError sends everything written so far, committing to compress (or not)
+// even if the body is still below gzipMinSize
+func (g *gzipResponseWriter) FlushError() error {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.gz == nil && !g.passthrough {
+		if err := g.start(); err != nil {
+			return err
+		}
+	}
+	if g.gz != nil {
+		if err := g.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Close flushes the gzip stream, or writes out a buffered small response
+func (g *gzipResponseWriter) Close() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if g.passthrough || g.status == 0 {
+		return nil
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	_, err := g.ResponseWriter.Write(g.buf)
+	return err
+}
+
+// GzipMiddleware compresses responses for clients that accept gzip
+func GzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// gzip;q=0 explicitly refuses the encoding
+		return strings.ReplaceAll(params, " ", "") != "q=0"
+	}
+	return false
+}
+
+// requestDecoders maps each supported request Content-Encoding to a function
+// wrapping a body in its decompressor
+var requestDecoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip":    func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	"deflate": func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+}
+
+// decodedBody reads a decompressed request body, closing both the
+// decompressor and the original body
+type decodedBody struct {
+	io.ReadCloser
+	original io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if closeErr := b.original.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RequestDecodingMiddleware transparently decompresses request bodies sent
+// with one of the allowed Content-Encodings, so handlers always read plain
+// bytes; their MaxBytesReader limits then apply to the decompressed size.
+// Any other encoding is rejected with 415.
+func RequestDecodingMiddleware(allowed []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == "identity" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !slices.Contains(allowed, encoding) {
+				// RFC 7694: tell the client which encodings would work
+				w.Header().Set("Accept-Encoding", strings.Join(allowed, ", "))
+				writeErrorResponse(w, r, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, fmt.Sprintf("Unsupported Content-Encoding %q", encoding))
+				return
+			}
+
+			body, err := requestDecoders[encoding](r.Body)
+			if err != nil {
+				writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, fmt.Sprintf("Invalid request body: not valid %s data", encoding))
+				return
+			}
+			r.Body = &decodedBody{ReadCloser: body, original: r.Body}
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1 // the decompressed length is unknown
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gunzip decompresses the body of rec
+func gunzip(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("response is not gzip: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompressing response: %v", err)
+	}
+	return string(body)
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	large := `{"items":["` + strings.Repeat("widget", gzipMinSize) + `"]}`
+	small := `{"status":"ok"}`
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		body           string
+		encoding       string // Content-Encoding set by the handler
+		wantGzip       bool
+	}{
+		{"large body", "gzip, deflate", large, "", true},
+		{"small body", "gzip", small, "", false},
+		{"gzip not accepted", "deflate", large, "", false},
+		{"gzip refused", "gzip;q=0", large, "", false},
+		{"already encoded", "gzip", large, "br", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Length", "1")
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, tt.body)
+			}))
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusCreated)
+			}
+			if !tt.wantGzip {
+				if rec.Header().Get("Content-Encoding") != tt.encoding {
+					t.Errorf("Content-Encoding = %q, want %q", rec.Header().Get("Content-Encoding"), tt.encoding)
+				}
+				if rec.Body.String() != tt.body {
+					t.Errorf("body was altered: got %d bytes, want %d", rec.Body.Len(), len(tt.body))
+				}
+				return
+			}
+			if rec.Header().Get("Content-Encoding") != "gzip" {
+				t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+			}
+			if rec.Header().Get("Content-Length") != "" {
+				t.Errorf("Content-Length %q sent with a compressed body", rec.Header().Get("Content-Length"))
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", rec.Header().Get("Vary"))
+			}
+			if body := gunzip(t, rec); body != tt.body {
+				t.Errorf("decompressed body differs: got %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestGzipProductList(t *testing.T) {
+	server, h := newTestServer(t)
+	for range 20 {
+		createProduct(t, server.store, &Product{Name: "Widget", Description: strings.Repeat("A sturdy widget. ", 5), Price: 999, Currency: "USD", Stock: 1})
+	}
+
+	plain := serve(h, "GET", "/v1/products?limit=100", "")
+	assertStatus(t, plain, http.StatusOK)
+	compressed := serve(h, "GET", "/v1/products?limit=100", "", "Accept-Encoding", "gzip")
+	assertStatus(t, compressed, http.StatusOK)
+
+	if compressed.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", compressed.Header().Get("Content-Encoding"))
+	}
+	if body := gunzip(t, compressed); body != plain.Body.String() {
+		t.Errorf("decompressed list = %s, want %s", body, plain.Body.String())
+	}
+}
+
+// compress encodes body with the named Content-Encoding
+func compress(t *testing.T, encoding, body string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	io.WriteString(w, body)
+	if err := w.Close(); err != nil {
+		t.Fatalf("compressing body: %v", err)
+	}
+	return buf.String()
+}
+
+func TestRequestDecoding(t *testing.T) {
+	const widget = `{"name":"Widget","price":1,"stock":1}`
+	large := `{"name":"Widget","description":"` + strings.Repeat(" ", 4096) + `","price":1,"stock":1}`
+	tests := []struct {
+		name      string
+		encodings []string // REQUEST_ENCODINGS
+		encoding  string   // Content-Encoding sent
+		body      string
+		status    int
+		code      ErrorCode
+	}{
+		{"gzip", []string{"gzip"}, "gzip", compress(t, "gzip", widget), http.StatusCreated, ""},
+		{"gzip upper case", []string{"gzip"}, "GZIP", compress(t, "gzip", widget), http.StatusCreated, ""},
+		{"identity", []string{"gzip"}, "identity", widget, http.StatusCreated, ""},
+		{"deflate allowed", []string{"gzip", "deflate"}, "deflate", compress(t, "deflate", widget), http.StatusCreated, ""},
+		{"deflate not allowed", []string{"gzip"}, "deflate", compress(t, "deflate", widget), http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType},
+		{"unknown encoding", []string{"gzip"}, "br", widget, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType},
+		{"not gzip data", []string{"gzip"}, "gzip", widget, http.StatusBadRequest, ErrCodeInvalidBody},
+		{"decompressed size over limit", []string{"gzip"}, "gzip", compress(t, "gzip", large), http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) {
+				cfg.RequestEncodings = tt.encodings
+				cfg.MaxBodyBytes = 1024
+			})
+			rec := serve(h, "POST", "/v1/products", tt.body, "Content-Encoding", tt.encoding)
+			if tt.code == "" {
+				assertStatus(t, rec, tt.status)
+				if product := getProduct(t, h, "4"); product.Name != "Widget" {
+					t.Errorf("created product = %+v", product)
+				}
+				return
+			}
+			assertError(t, rec, tt.status, tt.code)
+			if tt.status == http.StatusUnsupportedMediaType {
+				if got, want := rec.Header().Get("Accept-Encoding"), strings.Join(tt.encodings, ", "); got != want {
+					t.Errorf("Accept-Encoding = %q, want %q", got, want)
+				}
+			}
+		})
+	}
+
+	// CSV imports are decompressed the same way
+	_, h := newTestServer(t)
+	body := compress(t, "gzip", strings.Join(csvHeader, ",")+"\n,Croissant,,2.50,EUR,3,Bakery,,\n")
+	rec := serve(h, "POST", "/v1/products/import", body, "Content-Type", "text/csv", "Content-Encoding", "gzip")
+	assertStatus(t, rec, http.StatusOK)
+	if summary := decodeBody[ImportSummary](t, rec); summary.Created != 1 {
+		t.Errorf("gzipped CSV import summary = %+v, want one product created", summary)
+	}
+}
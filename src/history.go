@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultHistoryDepth is the number of changes kept per product, unless
+// HISTORY_DEPTH overrides it
+const defaultHistoryDepth = 20
+
+// HistoryEntry records one change to a product and its state afterwards
+type HistoryEntry struct {
+	XMLName   xml.Name  `json:"-" xml:"change"`
+	Timestamp time.Time `json:"timestamp" xml:"timestamp"`
+	Operation string    `json:"operation" xml:"operation"` // one of the audit operations
+	Version   int       `json:"version,omitempty" xml:"version,omitempty"`
+	Product   *Product  `json:"product,omitempty" xml:"product,omitempty"` // absent for delete
+}
+
+// ProductHistory keeps the most recent changes of every product, oldest
+// first, dropping the oldest once a product has depth of them
+type ProductHistory struct {
+	mu      sync.Mutex
+	depth   int
+	entries map[int32][]HistoryEntry
+}
+
+// NewProductHistory creates a history keeping depth changes per product
+func NewProductHistory(depth int) *ProductHistory {
+	return &ProductHistory{depth: depth, entries: make(map[int32][]HistoryEntry)}
+}
+
+// Record appends a change to product id's history
+func (h *ProductHistory) Record(id int32, entry HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := append(h.entries[id], entry)
+	if len(entries) > h.depth {
+		// Copy rather than reslice so dropped entries can be collected
+		entries = append([]HistoryEntry(nil), entries[len(entries)-h.depth:]...)
+	}
+	h.entries[id] = entries
+}
+
+// Get returns a copy of product id's changes, oldest first, and whether any
+// were recorded
+func (h *ProductHistory) Get(id int32) ([]HistoryEntry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries, exists := h.entries[id]
+	return append([]HistoryEntry{}, entries...), exists
+}
+
+// Clear forgets every product's history
+func (h *ProductHistory) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = make(map[int32][]HistoryEntry)
+}
+
+// enableHistory records every store write in a ProductHistory of depth
+// changes per product. A reset or import replaces products wholesale, so
+// their earlier history no longer applies and is dropped.
+func (s *Server) enableHistory(depth int) {
+	s.history = NewProductHistory(depth)
+	s.observeStore(func(event ProductEvent) {
+		if event.Type == EventReset || event.Type == EventImport {
+			s.history.Clear()
+			return
+		}
+		entry := HistoryEntry{Timestamp: event.Time, Operation: event.Type}
+		if event.Product != nil {
+			entry.Product = event.Product
+			entry.Version = event.Product.Version
+		}
+		s.history.Record(event.ID, entry)
+	})
+}
+
+// HandleProductHistory handles GET /products/{productId}/history, returning
+// the product's recorded changes oldest first. Products that have not changed
+// since the server started (or since a reset or import) have an empty
+// history; IDs that were never used are not found.
+func (s *Server) HandleProductHistory(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	// A product with no recorded changes still has an (empty) history as long
+	// as the store holds it, even soft-deleted
+	entries, recorded := s.history.Get(productID)
+	if !recorded {
+		if _, err := s.store.GetProductIncludingDeleted(productID); err != nil {
+			writeStoreError(w, r, productID, err)
+			return
+		}
+	}
+	writeResponse(w, r, http.StatusOK, entries)
+}
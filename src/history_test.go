@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// historyOf fetches product id's history through h
+func historyOf(t *testing.T, h http.Handler, id string) []HistoryEntry {
+	t.Helper()
+	rec := serve(h, "GET", "/v1/products/"+id+"/history", "")
+	assertStatus(t, rec, http.StatusOK)
+	return decodeBody[[]HistoryEntry](t, rec)
+}
+
+func TestProductHistory(t *testing.T) {
+	_, h := newTestServer(t)
+	if entries := historyOf(t, h, "1"); len(entries) != 0 {
+		t.Fatalf("unchanged product history = %+v, want empty", entries)
+	}
+
+	assertStatus(t, serve(h, "PUT", "/v1/products/1", `{"name":"Gaming Laptop","price":1299,"stock":4}`), http.StatusOK)
+	assertStatus(t, serve(h, "POST", "/v1/products/1/purchase", `{"quantity":1}`), http.StatusOK)
+	assertStatus(t, serve(h, "DELETE", "/v1/products/1", ""), http.StatusNoContent)
+	assertStatus(t, serve(h, "POST", "/v1/products/1/restore", ""), http.StatusOK)
+
+	entries := historyOf(t, h, "1")
+	var operations []string
+	for _, entry := range entries {
+		operations = append(operations, entry.Operation)
+	}
+	if want := []string{EventUpdate, EventUpdate, EventDelete, EventRestore}; !slices.Equal(operations, want) {
+		t.Fatalf("operations = %v, want %v", operations, want)
+	}
+	if p := entries[0].Product; p == nil || p.Name != "Gaming Laptop" || entries[0].Version != 2 {
+		t.Errorf("first entry = %+v, want the renamed product at version 2", entries[0])
+	}
+	if p := entries[1].Product; p == nil || p.Stock != 3 || entries[1].Version != 3 {
+		t.Errorf("second entry = %+v, want stock 3 at version 3", entries[1])
+	}
+	if entries[2].Product != nil {
+		t.Errorf("delete entry carries product %+v", entries[2].Product)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Timestamp.Before(entries[i-1].Timestamp) {
+			t.Errorf("entry %d at %s precedes entry %d at %s", i, entries[i].Timestamp, i-1, entries[i-1].Timestamp)
+		}
+	}
+
+	// Other products keep their own history
+	if entries := historyOf(t, h, "2"); len(entries) != 0 {
+		t.Errorf("product 2 history = %+v, want empty", entries)
+	}
+	assertError(t, serve(h, "GET", "/v1/products/99/history", ""), http.StatusNotFound, ErrCodeProductNotFound)
+	assertError(t, serve(h, "GET", "/v1/products/0/history", ""), http.StatusBadRequest, ErrCodeInvalidProductID)
+}
+
+func TestProductHistoryDepth(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.HistoryDepth = 3 })
+	for i := range 5 {
+		body := fmt.Sprintf(`{"name":"Laptop v%d","price":1,"stock":1}`, i+1)
+		assertStatus(t, serve(h, "PUT", "/v1/products/1", body), http.StatusOK)
+	}
+
+	entries := historyOf(t, h, "1")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Product.Name)
+	}
+	if want := []string{"Laptop v3", "Laptop v4", "Laptop v5"}; !slices.Equal(names, want) {
+		t.Errorf("kept changes = %v, want the latest %v", names, want)
+	}
+}
+
+func TestProductHistoryUnrecorded(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			server, h := newTestServer(t, configure)
+			assertStatus(t, serve(h, "DELETE", "/v1/products/2", ""), http.StatusNoContent)
+			server.history.Clear()
+
+			// Stored products without recorded changes, soft-deleted ones too, have an empty history
+			for _, id := range []string{"1", "2"} {
+				if entries := historyOf(t, h, id); len(entries) != 0 {
+					t.Errorf("product %s history = %+v, want empty", id, entries)
+				}
+			}
+			assertError(t, serve(h, "GET", "/v1/products/99/history", ""), http.StatusNotFound, ErrCodeProductNotFound)
+		})
+	}
+}
+
+func TestProductHistoryReset(t *testing.T) {
+	_, h := newTestServer(t)
+	assertStatus(t, serve(h, "PUT", "/v1/products/1", `{"name":"Gaming Laptop","price":1,"stock":1}`), http.StatusOK)
+	assertStatus(t, serve(h, "DELETE", "/v1/products", ""), http.StatusNoContent)
+
+	// The reset product is gone along with its history
+	assertError(t, serve(h, "GET", "/v1/products/1/history", ""), http.StatusNotFound, ErrCodeProductNotFound)
+	assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Desk","price":1,"stock":1}`), http.StatusCreated)
+	if entries := historyOf(t, h, "1"); len(entries) != 1 || entries[0].Operation != EventCreate {
+		t.Errorf("history of the new product 1 = %+v, want just its creation", entries)
+	}
+}
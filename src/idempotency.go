@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyKeyHeader names the request header carrying a client's retry key
+	idempotencyKeyHeader = "Idempotency-Key"
+	// defaultIdempotencyTTL is how long a key's response is replayed for
+	defaultIdempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are the response headers stored and replayed with a cached response
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+// idempotentResponse is the recorded outcome of a request made with an
+// Idempotency-Key. It is pending until the first request finishes.
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	pending     bool
+	status      int
+	header      http.Header
+	body        []byte
+	expiresAt   time.Time
+}
+
+// IdempotencyCache remembers responses by Idempotency-Key so that a retried
+// create returns the original result instead of creating again
+type IdempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotentResponse
+}
+
+// NewIdempotencyCache creates a cache whose entries expire after ttl
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]*idempotentResponse),
+	}
+}
+
+// begin looks up key. It returns the finished response to replay, or claims
+// the key for a new request when it is unused or expired. A key still in
+// progress or used with a different request yields an error.
+func (c *IdempotencyCache) begin(key string, fingerprint [sha256.Size]byte) (*idempotentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, exists := c.entries[key]; exists && time.Now().Before(entry.expiresAt) {
+		switch {
+		case entry.fingerprint != fingerprint:
+			return nil, errIdempotencyKeyReused
+		case entry.pending:
+			return nil, errIdempotencyKeyInProgress
+		}
+		return entry, nil
+	}
+	c.entries[key] = &idempotentResponse{
+		fingerprint: fingerprint,
+		pending:     true,
+		expiresAt:   time.Now().Add(c.ttl),
+	}
+	return nil, nil
+}
+
+// finish stores the response recorded for key
+func (c *IdempotencyCache) finish(key string, rec *idempotencyRecorder) {
+	header := make(http.Header)
+	for _, name := range replayedHeaders {
+		if value := rec.Header().Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, exists := c.entries[key]; exists {
+		entry.pending = false
+		entry.status = rec.status
+		entry.header = header
+		entry.body = rec.body.Bytes()
+	}
+}
+
+// abandon releases key so the request can be retried, used when the first
+// attempt did not succeed
+func (c *IdempotencyCache) abandon(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// Clear forgets every key, so responses recorded before a store reset are
+// not replayed for products that no longer exist
+func (c *IdempotencyCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// prune removes entries that expired before now
+func (c *IdempotencyCache) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// RunPruning periodically removes expired entries until stop is closed
+func (c *IdempotencyCache) RunPruning(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.prune(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Errors returned by IdempotencyCache.begin
+var (
+	errIdempotencyKeyReused     = errors.New("idempotency key reused with a different request")
+	errIdempotencyKeyInProgress = errors.New("idempotency key in progress")
+)
+
+// idempotencyRecorder captures a response while passing it through to the client
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(statusCode int) {
+	rec.status = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Idempotent wraps a create handler so requests carrying an Idempotency-Key
+// run at most once per key. Successful responses are replayed for retries with
+// the same key and body; failures are not cached, so a corrected request may
+// reuse the key. Requests without the header are passed through unchanged.
+func (s *Server) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "Invalid Idempotency-Key header: must be at most 255 characters")
+			return
+		}
+
+		// Buffer the body so it can be fingerprinted and still read by the handler
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodyBytes))
+		if err != nil {
+			writeDecodeError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+		hash.Write(body)
+		var fingerprint [sha256.Size]byte
+		copy(fingerprint[:], hash.Sum(nil))
+
+		cached, err := s.idempotency.begin(key, fingerprint)
+		switch {
+		case errors.Is(err, errIdempotencyKeyReused):
+			writeErrorResponse(w, r, http.StatusConflict, ErrCodeIdempotencyKeyReused, "Idempotency-Key has already been used with a different request")
+			return
+		case errors.Is(err, errIdempotencyKeyInProgress):
+			writeErrorResponse(w, r, http.StatusConflict, ErrCodeIdempotencyKeyInProgress, "A request with this Idempotency-Key is still in progress")
+			return
+		case cached != nil:
+			slog.Debug("Replaying idempotent response", "key", key, "status", cached.status)
+			for name, values := range cached.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		succeeded := false
+		defer func() {
+			// Also runs on panic, so a failed attempt never holds the key
+			if !succeeded {
+				s.idempotency.abandon(key)
+			}
+		}()
+		next(rec, r)
+		if rec.status >= 200 && rec.status < 300 {
+			s.idempotency.finish(key, rec)
+			succeeded = true
+		}
+	}
+}
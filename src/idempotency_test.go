@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentCreateReplay(t *testing.T) {
+	_, h := newTestServer(t)
+	const body = `{"name":"Widget","price":1,"stock":1}`
+
+	first := serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, "retry-1")
+	assertStatus(t, first, http.StatusCreated)
+	retry := serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, "retry-1")
+	assertStatus(t, retry, http.StatusCreated)
+
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("replayed body = %s, want %s", retry.Body.String(), first.Body.String())
+	}
+	if got, want := retry.Header().Get("Location"), first.Header().Get("Location"); got != want {
+		t.Errorf("replayed Location = %q, want %q", got, want)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay is missing Idempotent-Replayed: true")
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("first response is marked as replayed")
+	}
+	if page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", "")); page.Total != 4 {
+		t.Errorf("total = %d, want 4: the retry must not create again", page.Total)
+	}
+
+	// Without a key every request creates
+	assertStatus(t, serve(h, "POST", "/v1/products", body), http.StatusCreated)
+	assertStatus(t, serve(h, "POST", "/v1/products", body), http.StatusCreated)
+	if page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", "")); page.Total != 6 {
+		t.Errorf("total = %d, want 6", page.Total)
+	}
+}
+
+func TestIdempotencyClearedWithStore(t *testing.T) {
+	tests := []struct {
+		name, method, target, body string
+		status                     int
+	}{
+		{"reset", "DELETE", "/v1/products", "", http.StatusNoContent},
+		{"replace import", "POST", "/v1/import?mode=replace", `{"nextId":10,"products":[{"id":1,"name":"Laptop","price":1,"stock":1}]}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			const body = `{"name":"Widget","price":1,"stock":1}`
+			assertStatus(t, serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, "retry-1"), http.StatusCreated)
+			assertStatus(t, serve(h, tt.method, tt.target, tt.body), tt.status)
+
+			// The product the first response described is gone, so the retry creates again
+			retry := serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, "retry-1")
+			assertStatus(t, retry, http.StatusCreated)
+			if retry.Header().Get("Idempotent-Replayed") != "" {
+				t.Error("retry was replayed from before the store was cleared")
+			}
+			created := decodeBody[Product](t, retry)
+			if stored := getProduct(t, h, strconv.Itoa(int(created.ID))); stored.Name != "Widget" {
+				t.Errorf("product %d = %+v, want the retried create", created.ID, stored)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyErrors(t *testing.T) {
+	_, h := newTestServer(t)
+	const body = `{"name":"Widget","price":1,"stock":1}`
+
+	assertStatus(t, serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, "key"), http.StatusCreated)
+	rec := serve(h, "POST", "/v1/products", `{"name":"Gadget","price":1,"stock":1}`, idempotencyKeyHeader, "key")
+	assertError(t, rec, http.StatusConflict, ErrCodeIdempotencyKeyReused)
+
+	// Failures are not cached, so a corrected request can reuse its key
+	assertFieldError(t, serve(h, "POST", "/v1/products", `{"name":"","price":1,"stock":1}`, idempotencyKeyHeader, "fixed"), "name")
+	assertStatus(t, serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, "fixed"), http.StatusCreated)
+
+	rec = serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, strings.Repeat("k", maxIdempotencyKeyLength+1))
+	assertError(t, rec, http.StatusBadRequest, ErrCodeInvalidParameter)
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	cache := NewIdempotencyCache(time.Minute)
+	fingerprint := sha256.Sum256([]byte("request"))
+
+	if cached, err := cache.begin("key", fingerprint); cached != nil || err != nil {
+		t.Fatalf("first begin = %v, %v; want a new entry", cached, err)
+	}
+	if _, err := cache.begin("key", fingerprint); !errors.Is(err, errIdempotencyKeyInProgress) {
+		t.Errorf("begin while pending = %v, want errIdempotencyKeyInProgress", err)
+	}
+
+	cache.abandon("key")
+	if cached, err := cache.begin("key", fingerprint); cached != nil || err != nil {
+		t.Errorf("begin after abandon = %v, %v; want a new entry", cached, err)
+	}
+
+	cache.prune(time.Now().Add(time.Minute))
+	if cached, err := cache.begin("key", fingerprint); cached != nil || err != nil {
+		t.Errorf("begin after expiry = %v, %v; want a new entry", cached, err)
+	}
+
+	cache.Clear()
+	if cached, err := cache.begin("key", fingerprint); cached != nil || err != nil {
+		t.Errorf("begin after Clear = %v, %v; want a new entry", cached, err)
+	}
+}
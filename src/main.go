@@ -1,241 +1,2811 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 // Product represents the product model based on OpenAPI schema
 type Product struct {
-	ID          int32   `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Stock       int32   `json:"stock"`
-	Category    string  `json:"category,omitempty"`
-	ImageURL    string  `json:"imageUrl,omitempty"`
+	XMLName     xml.Name   `json:"-" xml:"product"`
+	ID          int32      `json:"id" xml:"id"`
+	Name        string     `json:"name" xml:"name"`
+	Description string     `json:"description" xml:"description"`
+	Price       Cents      `json:"price" xml:"price"`
+	Currency    string     `json:"currency,omitempty" xml:"currency,omitempty"` // ISO 4217 code
+	Stock       int32      `json:"stock" xml:"stock"`
+	Category    string     `json:"category,omitempty" xml:"category,omitempty"`
+	Categories  []string   `json:"categories,omitempty" xml:"categories>category,omitempty"` // additional category tags
+	ImageURL    string     `json:"imageUrl,omitempty" xml:"imageUrl,omitempty"`
+	Version     int        `json:"version" xml:"version"` // incremented on every write
+	Deleted     bool       `json:"deleted,omitempty" xml:"deleted,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty" xml:"deletedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt" xml:"createdAt"` // set once, when the product is created
+	UpdatedAt   time.Time  `json:"updatedAt" xml:"updatedAt"` // set on every write
 }
 
 // Error represents the error response model
 type Error struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	XMLName   xml.Name  `json:"-" xml:"error"`
+	Code      int       `json:"code" xml:"code"`
+	ErrorCode ErrorCode `json:"errorCode" xml:"errorCode"`
+	Message   string    `json:"message" xml:"message"`
 }
 
-// ProductStore handles in-memory storage with thread safety
-type ProductStore struct {
+// ErrorCode is a stable, machine-readable identifier for a failure, so
+// clients can branch on it without parsing messages
+type ErrorCode string
+
+// Error codes returned in the errorCode field of error responses
+const (
+	ErrCodeInvalidProductID         ErrorCode = "INVALID_PRODUCT_ID"
+	ErrCodeInvalidParameter         ErrorCode = "INVALID_PARAMETER"
+	ErrCodeInvalidBody              ErrorCode = "INVALID_BODY"
+	ErrCodeInvalidQuantity          ErrorCode = "INVALID_QUANTITY"
+	ErrCodeValidationFailed         ErrorCode = "VALIDATION_FAILED"
+	ErrCodeBatchTooLarge            ErrorCode = "BATCH_TOO_LARGE"
+	ErrCodeBodyTooLarge             ErrorCode = "BODY_TOO_LARGE"
+	ErrCodeQueryTooLarge            ErrorCode = "QUERY_TOO_LARGE"
+	ErrCodeUnsupportedMediaType     ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	ErrCodeNotAcceptable            ErrorCode = "NOT_ACCEPTABLE"
+	ErrCodeUnauthorized             ErrorCode = "UNAUTHORIZED"
+	ErrCodeProductNotFound          ErrorCode = "PRODUCT_NOT_FOUND"
+	ErrCodeReservationNotFound      ErrorCode = "RESERVATION_NOT_FOUND"
+	ErrCodeRouteNotFound            ErrorCode = "ROUTE_NOT_FOUND"
+	ErrCodeMethodNotAllowed         ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrCodeInsufficientStock        ErrorCode = "INSUFFICIENT_STOCK"
+	ErrCodeStockLimitExceeded       ErrorCode = "STOCK_LIMIT_EXCEEDED"
+	ErrCodeProductLimitReached      ErrorCode = "PRODUCT_LIMIT_REACHED"
+	ErrCodeVersionConflict          ErrorCode = "VERSION_CONFLICT"
+	ErrCodeDuplicateName            ErrorCode = "DUPLICATE_NAME"
+	ErrCodeIdempotencyKeyReused     ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	ErrCodeIdempotencyKeyInProgress ErrorCode = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	ErrCodeRateLimited              ErrorCode = "RATE_LIMITED"
+	ErrCodeNotReady                 ErrorCode = "NOT_READY"
+	ErrCodeMaintenance              ErrorCode = "MAINTENANCE"
+	ErrCodeTimeout                  ErrorCode = "TIMEOUT"
+	ErrCodeInternal                 ErrorCode = "INTERNAL_ERROR"
+)
+
+// FieldError describes a validation failure for a single input field
+type FieldError struct {
+	Field   string `json:"field" xml:"field"`
+	Message string `json:"message" xml:"message"`
+}
+
+// ValidationError represents the field-level validation error response model
+type ValidationError struct {
+	XMLName   xml.Name     `json:"-" xml:"error"`
+	Code      int          `json:"code" xml:"code"`
+	ErrorCode ErrorCode    `json:"errorCode" xml:"errorCode"`
+	Message   string       `json:"message" xml:"message"`
+	Errors    []FieldError `json:"errors" xml:"errors>error"`
+}
+
+// CategoryCount represents a category in use and how many products carry it
+type CategoryCount struct {
+	XMLName  xml.Name `json:"-" xml:"category"`
+	Category string   `json:"category" xml:"name"`
+	Count    int      `json:"count" xml:"count"`
+}
+
+// ProductPage represents a paginated list of products
+type ProductPage struct {
+	XMLName xml.Name   `json:"-" xml:"products"`
+	Items   []*Product `json:"items" xml:"items>product"`
+	Total   int        `json:"total" xml:"total"`
+	Limit   int        `json:"limit" xml:"limit"`
+	Offset  int        `json:"offset" xml:"offset"`
+}
+
+// ProductSet is the response to a bulk lookup by ID: the products found, in
+// request order, and the IDs that do not exist
+type ProductSet struct {
+	XMLName xml.Name   `json:"-" xml:"products"`
+	Items   []*Product `json:"items" xml:"items>product"`
+	Missing []int32    `json:"missing" xml:"missing>id"`
+}
+
+// productSorts maps the list endpoint's sort values to orderings. Sorting is
+// stable over the ID-sorted store listing, so ID ascending breaks ties, except
+// for created_desc where the later ID of a batch created together comes first.
+var productSorts = map[string]func(a, b *Product) bool{
+	"id_asc":     func(a, b *Product) bool { return a.ID < b.ID },
+	"name_asc":   func(a, b *Product) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"name_desc":  func(a, b *Product) bool { return strings.ToLower(a.Name) > strings.ToLower(b.Name) },
+	"price_asc":  func(a, b *Product) bool { return a.Price < b.Price },
+	"price_desc": func(a, b *Product) bool { return a.Price > b.Price },
+	"created_desc": func(a, b *Product) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	},
+}
+
+// Pagination defaults for the product list
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// listFlushInterval is how many items a streamed product list writes between
+// flushes to the client
+const listFlushInterval = 50
+
+// routeListProducts names the product list route, which streams its response
+// and so is exempt from the buffering request timeout
+const routeListProducts = "listProducts"
+
+// defaultMaxBodyBytes is the default limit on request body size (1MB)
+const defaultMaxBodyBytes = 1 << 20
+
+// Default limits on the query string, overridable with MAX_QUERY_BYTES and
+// MAX_QUERY_PARAMS (0 disables a limit)
+const (
+	defaultMaxQueryBytes  = 4096
+	defaultMaxQueryParams = 50
+)
+
+// defaultCurrency is the ISO 4217 code given to products that omit one,
+// unless CURRENCY overrides it
+const defaultCurrency = "USD"
+
+// healthCheckTimeout bounds how long GET /health waits on the store
+const healthCheckTimeout = 2 * time.Second
+
+// notReadyRetryAfter is the Retry-After, in seconds, sent while not ready
+const notReadyRetryAfter = 5
+
+// defaultAuditLimit is the number of entries GET /audit returns by default
+const defaultAuditLimit = 100
+
+// maxBatchSize caps the number of products accepted by POST /products/batch
+const maxBatchSize = 1000
+
+// maxBulkIDs caps the number of IDs accepted by GET /products?ids=
+const maxBulkIDs = 100
+
+// maxCompareIDs caps the number of products GET /products/compare compares
+const maxCompareIDs = 5
+
+// defaultShutdownTimeout bounds how long graceful shutdown waits for in-flight
+// requests, unless SHUTDOWN_TIMEOUT overrides it
+const defaultShutdownTimeout = 10 * time.Second
+
+// drainLogInterval is how often shutdown logs the requests still in flight
+const drainLogInterval = time.Second
+
+// Store errors returned by stock operations
+var (
+	ErrProductNotFound   = errors.New("product not found")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrStockLimit        = errors.New("stock limit exceeded")
+	ErrVersionConflict   = errors.New("version conflict")
+)
+
+// StockLevel represents the lightweight availability response
+type StockLevel struct {
+	XMLName xml.Name `json:"-" xml:"stock"`
+	ID      int32    `json:"id" xml:"id"`
+	Stock   int32    `json:"stock" xml:"stock"`
+}
+
+// StoreStats summarizes the store's contents for GET /stats
+type StoreStats struct {
+	XMLName    xml.Name `json:"-" xml:"stats"`
+	Products   int      `json:"products" xml:"products"`
+	Deleted    int      `json:"deleted" xml:"deleted"` // soft-deleted products, which still count toward MAX_PRODUCTS
+	TotalStock int64    `json:"totalStock" xml:"totalStock"`
+	Categories int      `json:"categories" xml:"categories"`
+	NextID     int32    `json:"nextId" xml:"nextId"`
+}
+
+// InventoryValue is the response body of GET /products/value
+type InventoryValue struct {
+	XMLName    xml.Name `json:"-" xml:"inventoryValue"`
+	TotalValue Cents    `json:"totalValue" xml:"totalValue"`
+	Currency   string   `json:"currency" xml:"currency"`
+	Category   string   `json:"category,omitempty" xml:"category,omitempty"`
+	Products   int      `json:"products" xml:"products"`
+}
+
+// BatchUpdateItem reports the outcome of one update in POST /products/batch-update
+type BatchUpdateItem struct {
+	ID        int32        `json:"id" xml:"id"`
+	Status    int          `json:"status" xml:"status"`
+	ErrorCode ErrorCode    `json:"errorCode,omitempty" xml:"errorCode,omitempty"`
+	Message   string       `json:"message,omitempty" xml:"message,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty" xml:"errors>error,omitempty"`
+	Product   *Product     `json:"product,omitempty" xml:"product,omitempty"`
+}
+
+// BatchUpdateResult is the response body of POST /products/batch-update
+type BatchUpdateResult struct {
+	XMLName   xml.Name          `json:"-" xml:"batchUpdate"`
+	Succeeded int               `json:"succeeded" xml:"succeeded"`
+	Failed    int               `json:"failed" xml:"failed"`
+	Results   []BatchUpdateItem `json:"results" xml:"results>result"`
+}
+
+// PurchaseRequest represents the body of POST /products/{productId}/purchase
+type PurchaseRequest struct {
+	Quantity int32 `json:"quantity"`
+}
+
+// StockAdjustment represents the body of POST /products/{productId}/stock/adjust
+type StockAdjustment struct {
+	Delta int32 `json:"delta"`
+}
+
+// ProductUpdate is one replacement within a batch update. A non-zero
+// ExpectedVersion must match the stored version.
+type ProductUpdate struct {
+	ID              int32
+	Product         *Product
+	ExpectedVersion int
+}
+
+// Store is the product storage backend used by the server
+type Store interface {
+	GetProduct(id int32) (*Product, error)
+	GetProductIncludingDeleted(id int32) (*Product, error)
+	GetProducts(ids []int32) []*Product
+	AddOrUpdateProduct(id int32, product *Product) (*Product, error)
+	UpdateProduct(id int32, product *Product, expectedVersion int) (*Product, error)
+	UpdateProducts(updates []ProductUpdate) []error
+	CreateProduct(product *Product) (*Product, error)
+	CreateProducts(products []*Product) ([]*Product, error)
+	DecrementStock(id int32, qty int32) (*Product, error)
+	IncrementStock(id int32, qty int32) (*Product, error)
+	AdjustStock(id int32, delta int32, limit int32) (*Product, error)
+	DeleteProduct(id int32) error
+	RestoreProduct(id int32) (*Product, error)
+	ListProducts() []*Product
+	ListAllProducts() []*Product
+	ListCategories() []CategoryCount
+	Stats() StoreStats
+	Reset() error
+	Snapshot() (storeSnapshot, error)
+	Import(snapshot storeSnapshot, replace bool) error
+}
+
+// StorePinger is implemented by stores backed by something that can become
+// unreachable, such as a database
+type StorePinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthStatus is the response body of GET /health
+type HealthStatus struct {
+	XMLName xml.Name `json:"-" xml:"health"`
+	Status  string   `json:"status" xml:"status"`
+	Store   string   `json:"store" xml:"store"`
+	Error   string   `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// Compile-time checks that both backends satisfy Store
+var (
+	_ Store = (*ProductStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
+
+// storeShards is the number of independently locked partitions in a ProductStore
+const storeShards = 16
+
+// productShard is one partition of a ProductStore, holding the products whose
+// ID maps to it
+type productShard struct {
 	mu       sync.RWMutex
 	products map[int32]*Product
-	nextID   int32
+}
+
+// live returns the product with id unless it is missing or soft-deleted.
+// Callers must hold sh.mu.
+func (sh *productShard) live(id int32) (*Product, bool) {
+	product, exists := sh.products[id]
+	if !exists || product.Deleted {
+		return nil, false
+	}
+	return product, true
+}
+
+// replace stores product in place of the live product with id, preserving the
+// ID. The caller must hold sh.mu for writing.
+func (sh *productShard) replace(id int32, product *Product, expectedVersion int, now time.Time) error {
+	existing, exists := sh.live(id)
+	if !exists {
+		return ErrProductNotFound
+	}
+	if expectedVersion != 0 && existing.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	product.ID = id
+	product.Version = existing.Version + 1
+	product.Deleted, product.DeletedAt = false, nil
+	product.CreatedAt = existing.CreatedAt
+	product.UpdatedAt = now
+	sh.products[id] = product
+	return nil
+}
+
+// ProductStore handles in-memory storage with thread safety. Products are
+// spread over storeShards shards keyed by ID so writes to different products
+// don't contend. Lock order is idMu, then shards in index order; single-product
+// operations take only their own shard's lock.
+type ProductStore struct {
+	shards  [storeShards]productShard
+	idMu    sync.Mutex // guards nextID and firstID, and serializes ID assignment
+	nextID  int32
+	firstID int32 // where IDs start, and restart after Reset
 }
 
 // NewProductStore creates a new product store
 func NewProductStore() *ProductStore {
-	return &ProductStore{
-		products: make(map[int32]*Product),
-		nextID:   1,
+	s := &ProductStore{nextID: 1, firstID: 1}
+	for i := range s.shards {
+		s.shards[i].products = make(map[int32]*Product)
+	}
+	return s
+}
+
+// SetFirstID makes IDs start at id instead of 1. The next ID only ever moves
+// forward, so IDs already handed out are never reused.
+func (s *ProductStore) SetFirstID(id int32) {
+	s.idMu.Lock()
+	defer s.idMu.Unlock()
+	s.firstID = id
+	s.nextID = max(s.nextID, id)
+}
+
+// shard returns the shard owning id
+func (s *ProductStore) shard(id int32) *productShard {
+	return &s.shards[uint32(id)%storeShards]
+}
+
+// lockAll write-locks every shard for whole-store operations
+func (s *ProductStore) lockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+}
+
+// unlockAll releases the locks taken by lockAll
+func (s *ProductStore) unlockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.Unlock()
+	}
+}
+
+// rlockAll read-locks every shard so whole-store reads see a consistent snapshot
+func (s *ProductStore) rlockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.RLock()
+	}
+}
+
+// runlockAll releases the locks taken by rlockAll
+func (s *ProductStore) runlockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.RUnlock()
+	}
+}
+
+// eachProduct calls fn for every stored product, including soft-deleted ones.
+// Callers must hold every shard lock.
+func (s *ProductStore) eachProduct(fn func(*Product)) {
+	for i := range s.shards {
+		for _, product := range s.shards[i].products {
+			fn(product)
+		}
+	}
+}
+
+// GetProduct retrieves a product by ID (thread-safe read), returning
+// ErrProductNotFound when it is missing or soft-deleted
+func (s *ProductStore) GetProduct(id int32) (*Product, error) {
+	sh := s.shard(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	product, exists := sh.live(id)
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+	return product, nil
+}
+
+// GetProductIncludingDeleted retrieves a product by ID whether or not it is
+// soft-deleted (thread-safe read); callers tell the two apart by Deleted
+func (s *ProductStore) GetProductIncludingDeleted(id int32) (*Product, error) {
+	sh := s.shard(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	product, exists := sh.products[id]
+	if !exists {
+		return nil, ErrProductNotFound
 	}
+	return product, nil
 }
 
-// GetProduct retrieves a product by ID (thread-safe read)
-func (s *ProductStore) GetProduct(id int32) (*Product, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	product, exists := s.products[id]
-	return product, exists
+// GetProducts retrieves the products with the given IDs, in the order given,
+// skipping missing ones (thread-safe read locking each shard involved once)
+func (s *ProductStore) GetProducts(ids []int32) []*Product {
+	var used [storeShards]bool
+	for _, id := range ids {
+		used[uint32(id)%storeShards] = true
+	}
+	for i := range s.shards {
+		if used[i] {
+			s.shards[i].mu.RLock()
+			defer s.shards[i].mu.RUnlock()
+		}
+	}
+
+	products := make([]*Product, 0, len(ids))
+	for _, id := range ids {
+		if product, exists := s.shard(id).live(id); exists {
+			products = append(products, product)
+		}
+	}
+	return products
 }
 
 // AddOrUpdateProduct adds or updates product details (thread-safe write)
-func (s *ProductStore) AddOrUpdateProduct(id int32, product *Product) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	
-	// Check if product exists
-	if _, exists := s.products[id]; !exists {
-		return false
+func (s *ProductStore) AddOrUpdateProduct(id int32, product *Product) (*Product, error) {
+	return s.UpdateProduct(id, product, 0)
+}
+
+// UpdateProduct replaces an existing product and returns it as stored
+// (thread-safe write). When expectedVersion is non-zero it must match the
+// stored version, otherwise ErrVersionConflict is returned and nothing is written.
+func (s *ProductStore) UpdateProduct(id int32, product *Product, expectedVersion int) (*Product, error) {
+	sh := s.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if err := sh.replace(id, product, expectedVersion, time.Now().UTC()); err != nil {
+		return nil, err
 	}
-	
-	// Update the product, preserving the ID
-	product.ID = id
-	s.products[id] = product
-	return true
+	return product, nil
+}
+
+// UpdateProducts applies several independent updates while holding every
+// shard lock, so readers see either none or all of the batch. Each update
+// fails on its own with ErrProductNotFound or ErrVersionConflict.
+func (s *ProductStore) UpdateProducts(updates []ProductUpdate) []error {
+	s.lockAll()
+	defer s.unlockAll()
+
+	now := time.Now().UTC()
+	errs := make([]error, len(updates))
+	for i, u := range updates {
+		errs[i] = s.shard(u.ID).replace(u.ID, u.Product, u.ExpectedVersion, now)
+	}
+	return errs
 }
 
 // CreateProduct creates a new product (for initial data seeding)
-func (s *ProductStore) CreateProduct(product *Product) *Product {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	
-	product.ID = s.nextID
-	s.products[s.nextID] = product
-	s.nextID++
-	return product
+func (s *ProductStore) CreateProduct(product *Product) (*Product, error) {
+	created, err := s.CreateProducts([]*Product{product})
+	if err != nil {
+		return nil, err
+	}
+	return created[0], nil
+}
+
+// CreateProducts creates several products, assigning consecutive IDs. It
+// cannot fail; the error is there to satisfy Store.
+func (s *ProductStore) CreateProducts(products []*Product) ([]*Product, error) {
+	// Holding idMu keeps Reset and LoadFromFile from interleaving with the inserts
+	s.idMu.Lock()
+	defer s.idMu.Unlock()
+
+	now := time.Now().UTC()
+	for _, product := range products {
+		product.ID = s.nextID
+		product.Version = 1
+		product.Deleted, product.DeletedAt = false, nil
+		product.CreatedAt = now
+		product.UpdatedAt = now
+		s.nextID++
+
+		sh := s.shard(product.ID)
+		sh.mu.Lock()
+		sh.products[product.ID] = product
+		sh.mu.Unlock()
+	}
+	return products, nil
+}
+
+// modify applies fn to a copy of the live product with id and stores the
+// copy, so readers holding the old pointer are unaffected (thread-safe write)
+func (s *ProductStore) modify(id int32, fn func(updated *Product) error) (*Product, error) {
+	sh := s.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	product, exists := sh.live(id)
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+	updated := *product
+	if err := fn(&updated); err != nil {
+		return product, err
+	}
+	updated.Version++
+	updated.UpdatedAt = time.Now().UTC()
+	sh.products[id] = &updated
+	return &updated, nil
+}
+
+// DecrementStock atomically reduces a product's stock by qty, returning the
+// updated product (thread-safe write)
+func (s *ProductStore) DecrementStock(id int32, qty int32) (*Product, error) {
+	updated, err := s.modify(id, func(p *Product) error {
+		if p.Stock < qty {
+			return ErrInsufficientStock
+		}
+		p.Stock -= qty
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// IncrementStock atomically returns qty units to a product's stock (thread-safe write)
+func (s *ProductStore) IncrementStock(id int32, qty int32) (*Product, error) {
+	return s.modify(id, func(p *Product) error {
+		p.Stock += qty
+		return nil
+	})
+}
+
+// AdjustStock atomically applies a signed delta to a product's stock, failing
+// with ErrInsufficientStock below zero or ErrStockLimit above limit (0 for no
+// limit beyond int32) (thread-safe write)
+func (s *ProductStore) AdjustStock(id int32, delta int32, limit int32) (*Product, error) {
+	updated, err := s.modify(id, func(p *Product) error {
+		next, err := adjustedStock(p.Stock, delta, limit)
+		if err != nil {
+			return err
+		}
+		p.Stock = next
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// adjustedStock returns stock+delta, checking it stays within 0..limit
+func adjustedStock(stock int32, delta int32, limit int32) (int32, error) {
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+	next := int64(stock) + int64(delta)
+	switch {
+	case next < 0:
+		return stock, ErrInsufficientStock
+	case next > int64(limit):
+		return stock, ErrStockLimit
+	}
+	return int32(next), nil
+}
+
+// DeleteProduct soft-deletes a product by ID, hiding it from reads while
+// keeping it restorable (thread-safe write)
+func (s *ProductStore) DeleteProduct(id int32) error {
+	_, err := s.modify(id, func(p *Product) error {
+		now := time.Now().UTC()
+		p.Deleted = true
+		p.DeletedAt = &now
+		return nil
+	})
+	return err
+}
+
+// RestoreProduct undeletes a soft-deleted product (thread-safe write) and
+// returns it, or ErrProductNotFound unless there is one with id
+func (s *ProductStore) RestoreProduct(id int32) (*Product, error) {
+	sh := s.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	product, exists := sh.products[id]
+	if !exists || !product.Deleted {
+		return nil, ErrProductNotFound
+	}
+
+	updated := *product
+	updated.Deleted = false
+	updated.DeletedAt = nil
+	updated.Version++
+	updated.UpdatedAt = time.Now().UTC()
+	sh.products[id] = &updated
+	return &updated, nil
+}
+
+// Reset removes all products and restarts IDs at the first ID (thread-safe write).
+// This is destructive and intended primarily for test environments.
+func (s *ProductStore) Reset() error {
+	s.idMu.Lock()
+	defer s.idMu.Unlock()
+	s.lockAll()
+	defer s.unlockAll()
+
+	for i := range s.shards {
+		s.shards[i].products = make(map[int32]*Product)
+	}
+	s.nextID = s.firstID
+	return nil
+}
+
+// ListProducts returns all products that are not soft-deleted, sorted by ID
+// ascending (thread-safe read)
+func (s *ProductStore) ListProducts() []*Product {
+	return s.listProducts(false)
+}
+
+// ListAllProducts is ListProducts including soft-deleted products
+func (s *ProductStore) ListAllProducts() []*Product {
+	return s.listProducts(true)
+}
+
+// listProducts returns products sorted by ID, optionally including soft-deleted ones
+func (s *ProductStore) listProducts(includeDeleted bool) []*Product {
+	s.rlockAll()
+	defer s.runlockAll()
+
+	var products []*Product
+	s.eachProduct(func(product *Product) {
+		if !product.Deleted || includeDeleted {
+			products = append(products, product)
+		}
+	})
+	if products == nil {
+		products = []*Product{}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ID < products[j].ID
+	})
+	return products
+}
+
+// ListCategories returns the distinct non-empty categories with product counts,
+// sorted by name (thread-safe read)
+func (s *ProductStore) ListCategories() []CategoryCount {
+	s.rlockAll()
+	defer s.runlockAll()
+
+	counts := make(map[string]int)
+	s.eachProduct(func(product *Product) {
+		if product.Deleted {
+			return
+		}
+		for _, category := range product.allCategories() {
+			counts[category]++
+		}
+	})
+	return sortedCategoryCounts(counts)
+}
+
+// Stats computes summary counts in a single pass (thread-safe read)
+func (s *ProductStore) Stats() StoreStats {
+	s.idMu.Lock()
+	defer s.idMu.Unlock()
+	s.rlockAll()
+	defer s.runlockAll()
+
+	stats := StoreStats{NextID: s.nextID}
+	categories := make(map[string]struct{})
+	s.eachProduct(func(product *Product) {
+		if product.Deleted {
+			stats.Deleted++
+			return
+		}
+		stats.Products++
+		stats.TotalStock += int64(product.Stock)
+		for _, category := range product.allCategories() {
+			categories[category] = struct{}{}
+		}
+	})
+	stats.Categories = len(categories)
+	return stats
+}
+
+// allCategories returns the product's Category followed by its Categories tags,
+// without duplicates
+func (p *Product) allCategories() []string {
+	var all []string
+	seen := make(map[string]bool)
+	for _, c := range append([]string{p.Category}, p.Categories...) {
+		if c != "" && !seen[c] {
+			seen[c] = true
+			all = append(all, c)
+		}
+	}
+	return all
+}
+
+// inCategory reports whether category, compared case-insensitively, is the
+// product's Category or one of its tags
+func (p *Product) inCategory(category string) bool {
+	if strings.EqualFold(p.Category, category) {
+		return true
+	}
+	for _, c := range p.Categories {
+		if strings.EqualFold(c, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedCategoryCounts converts a category->count map into a slice sorted by category
+func sortedCategoryCounts(counts map[string]int) []CategoryCount {
+	categories := make([]CategoryCount, 0, len(counts))
+	for category, count := range counts {
+		categories = append(categories, CategoryCount{Category: category, Count: count})
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Category < categories[j].Category
+	})
+	return categories
 }
 
 // Server represents the HTTP server
 type Server struct {
-	store *ProductStore
+	store        Store
+	audit        *AuditLog
+	reservations *ReservationManager
+	idempotency  *IdempotencyCache
+	cache        *ResponseCache  // GET /products/{productId} cache; nil when CACHE_TTL is 0
+	names        *NameIndex      // product names in use; nil unless PREVENT_DUPLICATE_NAMES is set
+	history      *ProductHistory // recent changes per product
+	events       *EventBroker
+	ready        atomic.Bool  // set once the store is initialized and seeded
+	maintenance  atomic.Bool  // set while writes are rejected for maintenance
+	inFlight     atomic.Int64 // requests currently being handled
+	maxBodyBytes int64        // upper bound on accepted request body size
+	apiPrefix    string       // base path product routes are mounted under
+	maxStock     int32        // upper bound on product stock; 0 means unlimited
+	currency     string       // ISO 4217 code for products that omit one
+	maxProducts  int          // upper bound on live products; 0 means unlimited
+	strictJSON   bool         // reject request bodies with unknown fields
+	// createMu serializes creates between checking names or capacity and
+	// storing the new products, so two requests cannot both pass the check
+	createMu sync.Mutex
+	// collapseNameSpaces reduces internal whitespace runs in product names to
+	// a single space
+	collapseNameSpaces bool
 }
 
-// NewServer creates a new server instance
-func NewServer() *Server {
+// NewServer creates a new server instance backed by store and configured by
+// cfg. The store is used as-is, so callers (and tests) can inject any Store
+// implementation along with DefaultConfig().
+func NewServer(store Store, cfg Config) *Server {
 	server := &Server{
-		store: NewProductStore(),
+		store:              store,
+		audit:              NewAuditLog(defaultAuditCapacity),
+		reservations:       NewReservationManager(store, cfg.ReservationTTL),
+		idempotency:        NewIdempotencyCache(cfg.IdempotencyTTL),
+		maxBodyBytes:       cfg.MaxBodyBytes,
+		apiPrefix:          cfg.APIPrefix,
+		maxStock:           cfg.MaxStock,
+		currency:           cfg.Currency,
+		maxProducts:        cfg.MaxProducts,
+		strictJSON:         cfg.StrictJSON,
+		collapseNameSpaces: cfg.CollapseNameSpaces,
+		events:             NewEventBroker(),
+	}
+	server.observeStore(server.events.Publish)
+	server.enableHistory(cfg.HistoryDepth)
+	server.dropReservationsOnReset()
+	if cfg.PreventDuplicateNames {
+		server.enableDuplicateNameCheck()
 	}
-	// Seed some initial products for testing
-	server.seedData()
+	if cfg.CacheTTL > 0 {
+		server.enableCache(cfg.CacheTTL)
+	}
+	server.ready.Store(true)
 	return server
 }
 
-// seedData adds initial products for testing
-func (s *Server) seedData() {
-	products := []*Product{
-		{Name: "Laptop", Description: "High-performance laptop", Price: 999.99, Stock: 10, Category: "Electronics"},
-		{Name: "Mouse", Description: "Wireless mouse", Price: 29.99, Stock: 50, Category: "Electronics"},
-		{Name: "Keyboard", Description: "Mechanical keyboard", Price: 79.99, Stock: 30, Category: "Electronics"},
+// initStore prepares store for serving. When the store is in-memory and
+// dataFile holds a valid snapshot, it is loaded instead of seeded. Otherwise an
+// empty store is seeded when seed is true, from seedFile if one is given, with
+// currency filled in for seed products that omit one.
+func initStore(store Store, dataFile string, seed bool, seedFile string, currency string) error {
+	if memStore, ok := store.(*ProductStore); ok && dataFile != "" {
+		if err := memStore.LoadFromFile(dataFile); err == nil {
+			return nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Error loading data file, falling back to seed data", "path", dataFile, "error", err)
+		}
+	}
+	// Seed some initial products for testing, unless the backend already has data
+	if !seed || len(store.ListAllProducts()) > 0 {
+		return nil
+	}
+	products := defaultSeedProducts()
+	if seedFile != "" {
+		var err error
+		if products, err = loadSeedFile(seedFile); err != nil {
+			return err
+		}
+	}
+	for _, product := range products {
+		if product.Currency == "" {
+			product.Currency = currency
+		}
+	}
+	return seedData(store, products)
+}
+
+// defaultSeedProducts returns the built-in products used for testing
+func defaultSeedProducts() []*Product {
+	return []*Product{
+		{Name: "Laptop", Description: "High-performance laptop", Price: 99999, Stock: 10, Category: "Electronics"},
+		{Name: "Mouse", Description: "Wireless mouse", Price: 2999, Stock: 50, Category: "Electronics"},
+		{Name: "Keyboard", Description: "Mechanical keyboard", Price: 7999, Stock: 30, Category: "Electronics"},
+	}
+}
+
+// loadSeedFile reads and validates a JSON array of products to seed
+func loadSeedFile(path string) ([]*Product, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading seed file: %w", err)
+	}
+	var products []*Product
+	if err := json.Unmarshal(data, &products); err != nil {
+		return nil, fmt.Errorf("decoding seed file %s: %w", path, err)
 	}
-	
+	for i, product := range products {
+		if product == nil {
+			return nil, fmt.Errorf("seed file %s: product at index %d is null", path, i)
+		}
+		if fieldErrors := validateProduct(product); len(fieldErrors) > 0 {
+			return nil, fmt.Errorf("seed file %s: product at index %d: %s", path, i, fieldErrors[0].Message)
+		}
+	}
+	return products, nil
+}
+
+// seedData adds initial products for testing
+func seedData(store Store, products []*Product) error {
 	for _, p := range products {
-		s.store.CreateProduct(p)
+		if _, err := store.CreateProduct(p); err != nil {
+			return fmt.Errorf("seeding products: %w", err)
+		}
 	}
+	return nil
 }
 
 // HandleGetProduct handles GET /products/{productId}
 func (s *Server) HandleGetProduct(w http.ResponseWriter, r *http.Request) {
-	// Extract productId from path
-	vars := mux.Vars(r)
-	productIDStr := vars["productId"]
-	
-	// Parse and validate productId
-	productID64, err := strconv.ParseInt(productIDStr, 10, 32)
-	if err != nil || productID64 < 1 {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid product ID format")
+	// Extract and validate productId from path
+	productID, ok := parseProductID(w, r)
+	if !ok {
 		return
 	}
-	productID := int32(productID64)
-	
-	// Retrieve product from store
-	product, exists := s.store.GetProduct(productID)
-	if !exists {
-		writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Product with ID %d not found", productID))
+
+	// Retrieve product from the response cache or the store
+	product, etag, cachedBody, err := s.lookupProduct(productID)
+	if err != nil {
+		writeStoreError(w, r, productID, err)
 		return
 	}
-	
-	// Return successful response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(product); err != nil {
-		log.Printf("Error encoding product response: %v", err)
-	}
-}
 
-// HandleAddProductDetails handles POST /products/{productId}/details
-func (s *Server) HandleAddProductDetails(w http.ResponseWriter, r *http.Request) {
-	// Extract productId from path
-	vars := mux.Vars(r)
-	productIDStr := vars["productId"]
-	
-	// Parse and validate productId
-	productID64, err := strconv.ParseInt(productIDStr, 10, 32)
-	if err != nil || productID64 < 1 {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid product ID format")
-		return
+	// Skip the body when the client already has the current representation.
+	// If-Modified-Since is only consulted without If-None-Match (RFC 9110).
+	w.Header().Set("ETag", etag)
+	if !product.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", product.UpdatedAt.Format(http.TimeFormat))
 	}
-	productID := int32(productID64)
-	
-	// Parse request body
-	var product Product
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields() // Strict parsing
-	if err := decoder.Decode(&product); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatches(inm, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if notModifiedSince(r.Header.Get("If-Modified-Since"), product.UpdatedAt) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-	
-	// Validate required fields
-	if product.Name == "" || product.Price < 0 || product.Stock < 0 {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid product data: name is required, price and stock must be non-negative")
+
+	// ?fields= trims the JSON body to the requested fields
+	if fields := r.URL.Query().Get("fields"); fields != "" {
+		if prefersXML(r) {
+			writeErrorResponse(w, r, http.StatusNotAcceptable, ErrCodeNotAcceptable, "Sparse fields are only supported for JSON responses")
+			return
+		}
+		sparse, err := sparseProduct(product, fields)
+		if err != nil {
+			writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, err.Error())
+			return
+		}
+		writeResponse(w, r, http.StatusOK, sparse)
 		return
 	}
-	
-	// Update product in store
-	if !s.store.AddOrUpdateProduct(productID, &product) {
-		writeErrorResponse(w, http.StatusNotFound, fmt.Sprintf("Product with ID %d not found", productID))
+
+	// A cached body is the plain JSON representation; other forms are encoded afresh
+	if cachedBody != nil && !prefersXML(r) && r.URL.Query().Get("pretty") != "true" {
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(cachedBody)
 		return
 	}
-	
-	// Return 204 No Content on success
-	w.WriteHeader(http.StatusNoContent)
+
+	// Return successful response
+	writeResponse(w, r, http.StatusOK, product)
 }
 
-// writeErrorResponse writes an error response
-func writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	
-	errorResponse := Error{
-		Code:    statusCode,
-		Message: message,
+// lookupProduct returns the live product with id and its ETag, from the
+// response cache when enabled. body is the cached JSON encoding, or nil when
+// the cache is disabled.
+func (s *Server) lookupProduct(id int32) (product *Product, etag string, body []byte, err error) {
+	if s.cache == nil {
+		product, err = s.store.GetProduct(id)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return product, productETag(product), nil, nil
 	}
-	
-	if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
-		log.Printf("Error encoding error response: %v", err)
+
+	if entry, hit := s.cache.Get(id); hit {
+		return entry.product, entry.etag, entry.body, nil
+	}
+	generation := s.cache.Generation()
+	product, err = s.store.GetProduct(id)
+	if err != nil {
+		return nil, "", nil, err
 	}
+	etag = productETag(product)
+	s.cache.Put(product, etag, generation)
+	return product, etag, nil, nil
 }
 
-// LoggingMiddleware logs all incoming requests
-func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[%s] %s %s", r.Method, r.RequestURI, r.RemoteAddr)
-		next.ServeHTTP(w, r)
-	})
+// notModifiedSince reports whether a product last modified at updatedAt is
+// unchanged since the If-Modified-Since header value. HTTP dates have
+// one-second resolution, so updatedAt is truncated before comparing.
+func notModifiedSince(header string, updatedAt time.Time) bool {
+	if header == "" || updatedAt.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+	return !updatedAt.Truncate(time.Second).After(since)
 }
 
-// RecoveryMiddleware handles panics gracefully
-func RecoveryMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
-				writeErrorResponse(w, http.StatusInternalServerError, "Internal server error")
-			}
-		}()
-		next.ServeHTTP(w, r)
-	})
+// productFields is the set of JSON field names a Product can be trimmed to
+var productFields = jsonFieldNames(reflect.TypeOf(Product{}))
+
+// jsonFieldNames returns the JSON names of t's encoded struct fields
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
 }
 
-func main() {
-	// Create server
-	server := NewServer()
-	
-	// Setup routes
-	router := mux.NewRouter()
-	
-	// Apply middleware
-	router.Use(LoggingMiddleware)
-	router.Use(RecoveryMiddleware)
-	
-	// Product endpoints
-	router.HandleFunc("/products/{productId:[0-9]+}", server.HandleGetProduct).Methods("GET")
-	router.HandleFunc("/products/{productId:[0-9]+}/details", server.HandleAddProductDetails).Methods("POST")
-	
-	// Health check endpoint (useful for ECS)
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}).Methods("GET")
-	
-	// Start server
-	port := "8080"
-	log.Printf("Starting server on port %s", port)
-	log.Printf("Initial products seeded: 3 products available (IDs: 1, 2, 3)")
-	if err := http.ListenAndServe(":"+port, router); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+// sparseProduct returns product as a JSON object holding only the
+// comma-separated fields, or an error naming the first unknown field.
+// Requested fields that are omitted when empty stay omitted.
+func sparseProduct(product *Product, fields string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(product)
+	if err != nil {
+		return nil, err
+	}
+	var full map[string]json.RawMessage
+	if err := json.Unmarshal(data, &full); err != nil {
+		return nil, err
+	}
+
+	sparse := make(map[string]json.RawMessage)
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if !productFields[field] {
+			return nil, fmt.Errorf("Invalid fields: unknown field %q", field)
+		}
+		if value, ok := full[field]; ok {
+			sparse[field] = value
+		}
+	}
+	return sparse, nil
+}
+
+// HandleGetStock handles GET /products/{productId}/stock
+func (s *Server) HandleGetStock(w http.ResponseWriter, r *http.Request) {
+	// Extract and validate productId from path
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := s.store.GetProduct(productID)
+	if err != nil {
+		writeStoreError(w, r, productID, err)
+		return
 	}
-}
\ No newline at end of file
+	writeResponse(w, r, http.StatusOK, StockLevel{ID: product.ID, Stock: product.Stock})
+}
+
+// HandleListProducts handles GET /products
+func (s *Server) HandleListProducts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// ids= switches to a bulk lookup instead of a paginated listing
+	if query.Has("ids") {
+		s.handleGetProductsByID(w, r, query.Get("ids"))
+		return
+	}
+
+	// Parse and validate pagination parameters
+	limit, err := parseNonNegativeInt(query.Get("limit"), defaultPageLimit)
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "Invalid limit: must be a non-negative integer")
+		return
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	offset, err := parseNonNegativeInt(query.Get("offset"), 0)
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "Invalid offset: must be a non-negative integer")
+		return
+	}
+
+	// Filter and sort before paginating so pages follow the requested order
+	products, ok := s.queryProducts(w, r)
+	if !ok {
+		return
+	}
+
+	// Spreadsheet clients asking for CSV get the full filtered catalog
+	if acceptsCSV(r) {
+		writeProductsCSV(w, products)
+		return
+	}
+
+	// Slice the filtered products so pages are stable across requests
+	page := ProductPage{
+		Items:  paginate(products, offset, limit),
+		Total:  len(products),
+		Limit:  limit,
+		Offset: offset,
+	}
+	setPaginationHeaders(w, r, page.Total, offset, limit)
+
+	// Return successful response, streaming compact JSON item by item
+	if prefersXML(r) || query.Get("pretty") == "true" {
+		writeResponse(w, r, http.StatusOK, page)
+		return
+	}
+	writeProductPageJSON(w, page)
+}
+
+// writeProductPageJSON writes page as JSON one item at a time, flushing every
+// listFlushInterval items, so a large page is never held in memory in full.
+// The items are a snapshot already: stored products are replaced rather than
+// modified, so no store lock is needed while writing. Once the status is sent
+// an error can only be logged, and the truncated body leaves the client with
+// invalid JSON rather than a silently short list.
+func writeProductPageJSON(w http.ResponseWriter, page ProductPage) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	buf := bufio.NewWriter(w)
+
+	buf.WriteString(`{"items":[`)
+	for i, product := range page.Items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		data, err := json.Marshal(product)
+		if err != nil {
+			slog.Error("Error encoding product in list response", "id", product.ID, "error", err)
+			return
+		}
+		if _, err := buf.Write(data); err != nil {
+			slog.Debug("Client went away during list response", "error", err)
+			return
+		}
+		if (i+1)%listFlushInterval == 0 {
+			if err := buf.Flush(); err != nil {
+				slog.Debug("Client went away during list response", "error", err)
+				return
+			}
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return
+			}
+		}
+	}
+	fmt.Fprintf(buf, `],"total":%d,"limit":%d,"offset":%d}`+"\n", page.Total, page.Limit, page.Offset)
+	if err := buf.Flush(); err != nil {
+		slog.Debug("Client went away during list response", "error", err)
+	}
+}
+
+// HandleExportCSV handles GET /products.csv, honoring the list filters
+func (s *Server) HandleExportCSV(w http.ResponseWriter, r *http.Request) {
+	products, ok := s.queryProducts(w, r)
+	if !ok {
+		return
+	}
+	writeProductsCSV(w, products)
+}
+
+// queryProducts returns the products matching the request's list filters in
+// the requested sort order, writing a 400 and returning false when invalid
+func (s *Server) queryProducts(w http.ResponseWriter, r *http.Request) ([]*Product, bool) {
+	query := r.URL.Query()
+
+	// Soft-deleted products are hidden unless explicitly requested
+	includeDeleted, ok := parseBoolQuery(w, r, "includeDeleted")
+	if !ok {
+		return nil, false
+	}
+	all := s.store.ListProducts
+	if includeDeleted {
+		all = s.store.ListAllProducts
+	}
+
+	// Apply filters to the ID-sorted products
+	products, err := filterProductsByQuery(all(), query)
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, err.Error())
+		return nil, false
+	}
+
+	sortKey := query.Get("sort")
+	if sortKey == "" {
+		sortKey = "id_asc"
+	}
+	less, ok := productSorts[sortKey]
+	if !ok {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, fmt.Sprintf("Invalid sort %q: must be one of id_asc, name_asc, name_desc, price_asc, price_desc, created_desc", sortKey))
+		return nil, false
+	}
+	sort.SliceStable(products, func(i, j int) bool {
+		return less(products[i], products[j])
+	})
+	return products, true
+}
+
+// handleGetProductsByID serves GET /products?ids=1,3,5, returning the products
+// found and reporting the rest as missing
+func (s *Server) handleGetProductsByID(w http.ResponseWriter, r *http.Request, value string) {
+	ids, err := parseIDList(value, maxBulkIDs)
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, err.Error())
+		return
+	}
+	writeResponse(w, r, http.StatusOK, s.lookupProducts(ids))
+}
+
+// lookupProducts returns the products with ids, in the order given, and the
+// IDs that do not exist
+func (s *Server) lookupProducts(ids []int32) ProductSet {
+	products := s.store.GetProducts(ids)
+	found := make(map[int32]bool, len(products))
+	for _, product := range products {
+		found[product.ID] = true
+	}
+	set := ProductSet{Items: products, Missing: []int32{}}
+	for _, id := range ids {
+		if !found[id] {
+			set.Missing = append(set.Missing, id)
+		}
+	}
+	return set
+}
+
+// parseIDList parses a comma-separated list of product IDs, dropping
+// duplicates and allowing at most limit
+func parseIDList(value string, limit int) ([]int32, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) > limit {
+		return nil, fmt.Errorf("Too many ids: at most %d allowed", limit)
+	}
+	ids := make([]int32, 0, len(parts))
+	seen := make(map[int32]bool, len(parts))
+	for _, part := range parts {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 32)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("Invalid ids: %q is not a valid product ID", part)
+		}
+		if !seen[int32(id)] {
+			seen[int32(id)] = true
+			ids = append(ids, int32(id))
+		}
+	}
+	return ids, nil
+}
+
+// HandleListCategories handles GET /categories
+func (s *Server) HandleListCategories(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, s.store.ListCategories())
+}
+
+// HandleAddProductDetails handles POST /products/{productId}/details
+func (s *Server) HandleAddProductDetails(w http.ResponseWriter, r *http.Request) {
+	// Extract and validate productId from path
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	// Honor an optional If-Match header carrying the expected version
+	expectedVersion, ok := parseIfMatch(w, r)
+	if !ok {
+		return
+	}
+	dryRun, ok := parseBoolQuery(w, r, "dryRun")
+	if !ok {
+		return
+	}
+
+	// Parse and validate request body
+	var product Product
+	if !s.decodeProduct(w, r, &product) {
+		return
+	}
+
+	// A dry run reports the would-be product without writing it
+	if dryRun {
+		if s.previewUpdate(w, r, productID, &product, expectedVersion) {
+			writeResponse(w, r, http.StatusOK, &product)
+		}
+		return
+	}
+
+	// Update product in store
+	if _, err := s.store.UpdateProduct(productID, &product, expectedVersion); err != nil {
+		writeStoreError(w, r, productID, err)
+		return
+	}
+	s.recordAudit(r, AuditUpdate, productID)
+
+	// Return 204 No Content on success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleReplaceProduct handles PUT /products/{productId}
+func (s *Server) HandleReplaceProduct(w http.ResponseWriter, r *http.Request) {
+	// Extract and validate productId from path
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	// Honor an optional If-Match header carrying the expected version
+	expectedVersion, ok := parseIfMatch(w, r)
+	if !ok {
+		return
+	}
+	dryRun, ok := parseBoolQuery(w, r, "dryRun")
+	if !ok {
+		return
+	}
+
+	// Parse and validate the full replacement product
+	var product Product
+	if !s.decodeProduct(w, r, &product) {
+		return
+	}
+
+	// A dry run reports the would-be product without writing it
+	if dryRun {
+		if s.previewUpdate(w, r, productID, &product, expectedVersion) {
+			writeResponse(w, r, http.StatusOK, &product)
+		}
+		return
+	}
+
+	// Replace product in store, preserving the path ID
+	updated, err := s.store.UpdateProduct(productID, &product, expectedVersion)
+	if err != nil {
+		writeStoreError(w, r, productID, err)
+		return
+	}
+	s.recordAudit(r, AuditUpdate, productID)
+
+	// Return the updated product
+	writeResponse(w, r, http.StatusOK, updated)
+}
+
+// lockCreates holds off other creates while new products are checked against
+// PREVENT_DUPLICATE_NAMES or MAX_PRODUCTS, returning the function that
+// releases them. It is a no-op when neither is enabled.
+func (s *Server) lockCreates() func() {
+	if s.names == nil && s.maxProducts == 0 {
+		return func() {}
+	}
+	s.createMu.Lock()
+	return s.createMu.Unlock
+}
+
+// productCapacity returns how many more products MAX_PRODUCTS allows, or -1
+// when it is unlimited. Soft-deleted products still occupy the store and can
+// be restored, so they count toward the limit. Callers must hold lockCreates.
+func (s *Server) productCapacity() int {
+	if s.maxProducts == 0 {
+		return -1
+	}
+	stats := s.store.Stats()
+	return max(s.maxProducts-stats.Products-stats.Deleted, 0)
+}
+
+// checkCapacity writes a 507 and returns false when creating n products would
+// exceed MAX_PRODUCTS. Callers must hold lockCreates.
+func (s *Server) checkCapacity(w http.ResponseWriter, r *http.Request, n int) bool {
+	capacity := s.productCapacity()
+	if capacity < 0 || n <= capacity {
+		return true
+	}
+	msg := fmt.Sprintf("Product limit reached: at most %d products allowed", s.maxProducts)
+	if capacity > 0 {
+		msg = fmt.Sprintf("%s, only %d more can be created", msg, capacity)
+	}
+	writeErrorResponse(w, r, http.StatusInsufficientStorage, ErrCodeProductLimitReached, msg)
+	return false
+}
+
+// HandleCreateProduct handles POST /products
+func (s *Server) HandleCreateProduct(w http.ResponseWriter, r *http.Request) {
+	dryRun, ok := parseBoolQuery(w, r, "dryRun")
+	if !ok {
+		return
+	}
+
+	// Parse and validate request body
+	var product Product
+	if !s.decodeProduct(w, r, &product) {
+		return
+	}
+	unlock := s.lockCreates()
+	defer unlock()
+	if !s.checkNames(w, r, &product) || !s.checkCapacity(w, r, 1) {
+		return
+	}
+
+	// A dry run reports the would-be product; no ID is assigned
+	if dryRun {
+		product.ID = 0
+		product.Version = 1
+		product.CreatedAt = time.Now().UTC()
+		product.UpdatedAt = product.CreatedAt
+		writeResponse(w, r, http.StatusOK, &product)
+		return
+	}
+
+	// Create product in store; the ID is always assigned by the store
+	created, err := s.store.CreateProduct(&product)
+	if err != nil {
+		writeStoreError(w, r, 0, err)
+		return
+	}
+	s.recordAudit(r, AuditCreate, created.ID)
+
+	// Return 201 Created with the new product
+	w.Header().Set("Location", fmt.Sprintf("%s/%s/products/%d", s.apiPrefix, APIVersionFromContext(r.Context()), created.ID))
+	writeResponse(w, r, http.StatusCreated, created)
+}
+
+// HandleDuplicateProduct handles POST /products/{productId}/duplicate, creating
+// a copy of the product under a new ID. " (copy)" is appended to the name
+// unless ?keepName=true.
+func (s *Server) HandleDuplicateProduct(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+	keepName, ok := parseBoolQuery(w, r, "keepName")
+	if !ok {
+		return
+	}
+
+	source, err := s.store.GetProduct(productID)
+	if err != nil {
+		writeStoreError(w, r, productID, err)
+		return
+	}
+
+	// Copy only the client-settable fields; the store assigns the rest
+	product := Product{
+		Name:        source.Name,
+		Description: source.Description,
+		Price:       source.Price,
+		Currency:    source.Currency,
+		Stock:       source.Stock,
+		Category:    source.Category,
+		Categories:  append([]string(nil), source.Categories...),
+		ImageURL:    source.ImageURL,
+	}
+	if !keepName {
+		product.Name += " (copy)"
+	}
+	if fieldErrors := s.validate(&product); len(fieldErrors) > 0 {
+		writeValidationError(w, r, fieldErrors)
+		return
+	}
+	unlock := s.lockCreates()
+	defer unlock()
+	if !s.checkNames(w, r, &product) || !s.checkCapacity(w, r, 1) {
+		return
+	}
+
+	created, err := s.store.CreateProduct(&product)
+	if err != nil {
+		writeStoreError(w, r, 0, err)
+		return
+	}
+	s.recordAudit(r, AuditCreate, created.ID)
+	w.Header().Set("Location", fmt.Sprintf("%s/%s/products/%d", s.apiPrefix, APIVersionFromContext(r.Context()), created.ID))
+	writeResponse(w, r, http.StatusCreated, created)
+}
+
+// HandleBatchCreate handles POST /products/batch
+func (s *Server) HandleBatchCreate(w http.ResponseWriter, r *http.Request) {
+	// Parse request body as an array of products, keeping each item as sent
+	// for the required-field check
+	var body json.RawMessage
+	if !s.decodeJSONBody(w, r, &body) {
+		return
+	}
+	var products []*Product
+	if !s.unmarshalJSON(w, r, body, &products) {
+		return
+	}
+	if len(products) > maxBatchSize {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeBatchTooLarge, fmt.Sprintf("Batch too large: at most %d products allowed", maxBatchSize))
+		return
+	}
+	var items []json.RawMessage
+	json.Unmarshal(body, &items) // cannot fail once body decoded as an array
+
+	// Validate every item before creating any, so the batch is all-or-nothing.
+	// Field names are prefixed with the item index, e.g. "[2].price".
+	var fieldErrors []FieldError
+	for i, item := range items {
+		for _, fe := range validateProductJSON(item) {
+			fe.Field = fmt.Sprintf("[%d].%s", i, fe.Field)
+			fieldErrors = append(fieldErrors, fe)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationError(w, r, fieldErrors)
+		return
+	}
+	for i, product := range products {
+		if product == nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("[%d]", i), Message: "product must not be null"})
+			continue
+		}
+		for _, fe := range s.validate(product) {
+			fe.Field = fmt.Sprintf("[%d].%s", i, fe.Field)
+			fieldErrors = append(fieldErrors, fe)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationError(w, r, fieldErrors)
+		return
+	}
+	unlock := s.lockCreates()
+	defer unlock()
+	if !s.checkNames(w, r, products...) || !s.checkCapacity(w, r, len(products)) {
+		return
+	}
+
+	created, err := s.store.CreateProducts(products)
+	if err != nil {
+		writeStoreError(w, r, 0, err)
+		return
+	}
+	if created == nil {
+		created = []*Product{}
+	}
+	for _, product := range created {
+		s.recordAudit(r, AuditCreate, product.ID)
+	}
+
+	// Return 201 Created with the new products
+	writeResponse(w, r, http.StatusCreated, created)
+}
+
+// HandleBatchUpdate handles POST /products/batch-update. Each item is a full
+// product replacement carrying its id, and optionally the version it expects.
+// Items succeed or fail independently and each gets its own result, in
+// request order; the valid ones are applied under a single store lock.
+func (s *Server) HandleBatchUpdate(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if !s.decodeJSONBody(w, r, &body) {
+		return
+	}
+	var products []*Product
+	if !s.unmarshalJSON(w, r, body, &products) {
+		return
+	}
+	if len(products) > maxBatchSize {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeBatchTooLarge, fmt.Sprintf("Batch too large: at most %d products allowed", maxBatchSize))
+		return
+	}
+	var items []json.RawMessage
+	json.Unmarshal(body, &items) // cannot fail once body decoded as an array
+
+	results := make([]BatchUpdateItem, len(products))
+	var updates []ProductUpdate
+	var pending []int // index into results of each update
+	for i, product := range products {
+		switch {
+		case product == nil:
+			results[i] = BatchUpdateItem{Status: http.StatusBadRequest, ErrorCode: ErrCodeInvalidBody, Message: "product must not be null"}
+			continue
+		case product.ID < 1:
+			results[i] = BatchUpdateItem{ID: product.ID, Status: http.StatusBadRequest, ErrorCode: ErrCodeInvalidProductID, Message: "id must be a positive integer"}
+			continue
+		}
+		results[i].ID = product.ID
+		// Missing fields are reported alone; their zero values would only add noise
+		fieldErrors := validateProductJSON(items[i])
+		if len(fieldErrors) == 0 {
+			fieldErrors = s.validate(product)
+		}
+		if len(fieldErrors) > 0 {
+			results[i].Status = http.StatusUnprocessableEntity
+			results[i].ErrorCode = ErrCodeValidationFailed
+			results[i].Message = "Validation failed"
+			results[i].Errors = fieldErrors
+			continue
+		}
+		updates = append(updates, ProductUpdate{ID: product.ID, Product: product, ExpectedVersion: product.Version})
+		pending = append(pending, i)
+	}
+
+	var errs []error
+	if len(updates) > 0 {
+		errs = s.store.UpdateProducts(updates)
+	}
+	for j, err := range errs {
+		item := &results[pending[j]]
+		switch {
+		case err == nil:
+			item.Status = http.StatusOK
+			item.Product = updates[j].Product
+			s.recordAudit(r, AuditUpdate, item.ID)
+		case errors.Is(err, ErrProductNotFound):
+			item.Status, item.ErrorCode = http.StatusNotFound, ErrCodeProductNotFound
+			item.Message = fmt.Sprintf("Product with ID %d not found", item.ID)
+		case errors.Is(err, ErrVersionConflict):
+			item.Status, item.ErrorCode = http.StatusConflict, ErrCodeVersionConflict
+			item.Message = fmt.Sprintf("Product %d has been modified: version does not match", item.ID)
+		default:
+			slog.Error("Error applying batch update", "id", item.ID, "error", err)
+			item.Status, item.ErrorCode = http.StatusInternalServerError, ErrCodeInternal
+			item.Message = "Internal server error"
+		}
+	}
+
+	result := BatchUpdateResult{Results: results}
+	for _, item := range results {
+		if item.Status == http.StatusOK {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+	writeResponse(w, r, http.StatusOK, result)
+}
+
+// HandlePurchase handles POST /products/{productId}/purchase
+func (s *Server) HandlePurchase(w http.ResponseWriter, r *http.Request) {
+	// Extract and validate productId from path
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req PurchaseRequest
+	if !s.decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !s.validQuantity(w, r, req.Quantity) {
+		return
+	}
+
+	// Decrement stock atomically in the store
+	product, err := s.store.DecrementStock(productID, req.Quantity)
+	if err != nil {
+		writeStoreError(w, r, productID, err)
+		return
+	}
+	s.recordAudit(r, AuditUpdate, productID)
+
+	// Return the product as the decrement left it; a re-fetch could observe
+	// a later write
+	writeResponse(w, r, http.StatusOK, product)
+}
+
+// HandleAdjustStock handles POST /products/{productId}/stock/adjust
+func (s *Server) HandleAdjustStock(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	var req StockAdjustment
+	if !s.decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Delta == 0 {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidQuantity, "Invalid delta: must not be zero")
+		return
+	}
+
+	product, err := s.store.AdjustStock(productID, req.Delta, s.maxStock)
+	if err != nil {
+		writeStoreError(w, r, productID, err)
+		return
+	}
+	s.recordAudit(r, AuditUpdate, productID)
+	writeResponse(w, r, http.StatusOK, StockLevel{ID: productID, Stock: product.Stock})
+}
+
+// previewUpdate performs the existence and If-Match checks of an update
+// without writing, filling in the ID and version product would be stored
+// with. It writes an error response and returns false when the update would fail.
+func (s *Server) previewUpdate(w http.ResponseWriter, r *http.Request, productID int32, product *Product, expectedVersion int) bool {
+	existing, err := s.store.GetProduct(productID)
+	if err != nil {
+		writeStoreError(w, r, productID, err)
+		return false
+	}
+	if expectedVersion != 0 && existing.Version != expectedVersion {
+		writeStoreError(w, r, productID, ErrVersionConflict)
+		return false
+	}
+	product.ID = productID
+	product.Version = existing.Version + 1
+	product.CreatedAt = existing.CreatedAt
+	product.UpdatedAt = time.Now().UTC()
+	return true
+}
+
+// validQuantity checks a purchase or reservation quantity, writing a 400 and
+// returning false when it is out of range
+func (s *Server) validQuantity(w http.ResponseWriter, r *http.Request, qty int32) bool {
+	if qty < 1 {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidQuantity, "Invalid quantity: must be at least 1")
+		return false
+	}
+	if s.maxStock > 0 && qty > s.maxStock {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidQuantity, fmt.Sprintf("Invalid quantity: must be at most %d", s.maxStock))
+		return false
+	}
+	return true
+}
+
+// HandleDeleteProduct handles DELETE /products/{productId}
+func (s *Server) HandleDeleteProduct(w http.ResponseWriter, r *http.Request) {
+	// Extract and validate productId from path
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	// Soft-delete the product so it can be restored later
+	if err := s.store.DeleteProduct(productID); err != nil {
+		writeStoreError(w, r, productID, err)
+		return
+	}
+	s.recordAudit(r, AuditDelete, productID)
+
+	// Return 204 No Content on success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRestoreProduct handles POST /products/{productId}/restore
+func (s *Server) HandleRestoreProduct(w http.ResponseWriter, r *http.Request) {
+	// Extract and validate productId from path
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := s.store.RestoreProduct(productID)
+	if errors.Is(err, ErrProductNotFound) {
+		writeErrorResponse(w, r, http.StatusNotFound, ErrCodeProductNotFound, fmt.Sprintf("Deleted product with ID %d not found", productID))
+		return
+	} else if err != nil {
+		writeStoreError(w, r, productID, err)
+		return
+	}
+	s.recordAudit(r, AuditRestore, productID)
+
+	// Return the product as the restore left it; a re-fetch could observe
+	// a later write, or miss the product if it was deleted again meanwhile
+	writeResponse(w, r, http.StatusOK, product)
+}
+
+// HandleAudit handles GET /audit, returning the most recent mutations first
+func (s *Server) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseNonNegativeInt(r.URL.Query().Get("limit"), defaultAuditLimit)
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "Invalid limit: must be a non-negative integer")
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, s.audit.Recent(limit))
+}
+
+// recordAudit records a mutation of productID along with the request's ID
+func (s *Server) recordAudit(r *http.Request, operation string, productID int32) {
+	requestID := RequestIDFromContext(r.Context())
+	slog.Debug("Product written", "operation", operation, "id", productID, "request_id", requestID)
+	s.audit.Record(operation, productID, requestID)
+}
+
+// HandleResetProducts handles DELETE /products, clearing the whole store.
+// This is destructive and intended primarily for resetting test environments;
+// it is guarded by the API key whenever authentication is enabled.
+func (s *Server) HandleResetProducts(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.Reset(); err != nil {
+		writeStoreError(w, r, 0, err)
+		return
+	}
+	s.idempotency.Clear()
+	s.recordAudit(r, AuditReset, 0)
+	slog.Warn("Product store reset", "request_id", RequestIDFromContext(r.Context()))
+
+	// Return 204 No Content on success
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleInventoryValue handles GET /products/value, summing price*stock over
+// live products in one currency (?currency=, default CURRENCY), optionally
+// limited to a ?category=. Prices are whole cents, so the sum is exact.
+func (s *Server) HandleInventoryValue(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	currency := query.Get("currency")
+	if currency == "" {
+		currency = s.currency
+	} else if !validCurrency(currency) {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, fmt.Sprintf("Invalid currency %q: must be an ISO 4217 code", currency))
+		return
+	}
+	category := query.Get("category")
+
+	value := InventoryValue{Currency: currency, Category: category}
+	for _, p := range s.store.ListProducts() {
+		// Products stored before currencies existed are in the default currency
+		productCurrency := p.Currency
+		if productCurrency == "" {
+			productCurrency = s.currency
+		}
+		if productCurrency != currency || (category != "" && !p.inCategory(category)) {
+			continue
+		}
+		value.TotalValue += p.Price * Cents(p.Stock)
+		value.Products++
+	}
+	writeResponse(w, r, http.StatusOK, value)
+}
+
+// HandleStats handles GET /stats
+func (s *Server) HandleStats(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, r, http.StatusOK, s.store.Stats())
+}
+
+// HandleHealth handles GET /health, reporting whether the backing store is
+// reachable. ?simple=true skips the store check and answers a plain OK, for
+// liveness probes that should not fail when only the database is down.
+func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	simple, ok := parseBoolQuery(w, r, "simple")
+	if !ok {
+		return
+	}
+	if simple {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+		return
+	}
+
+	health := HealthStatus{Status: "ok", Store: "ok"}
+	status := http.StatusOK
+	if pinger, ok := s.store.(StorePinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+		if err := pinger.Ping(ctx); err != nil {
+			slog.Error("Store health check failed", "error", err)
+			health = HealthStatus{Status: "unavailable", Store: "unavailable", Error: err.Error()}
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeResponse(w, r, status, health)
+}
+
+// HandleReady handles GET /ready, reporting whether the server can take traffic
+func (s *Server) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeNotReady(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("READY"))
+}
+
+// ReadinessMiddleware answers 503 while the server is starting up or draining,
+// so requests never see a partially loaded store
+func (s *Server) ReadinessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			writeNotReady(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeNotReady writes a 503 telling the client when to retry
+func writeNotReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(notReadyRetryAfter))
+	writeErrorResponse(w, r, http.StatusServiceUnavailable, ErrCodeNotReady, "Server is not ready")
+}
+
+// productETag computes a weak ETag from a hash of the product's fields, so it
+// changes whenever any field of the product changes
+func productETag(product *Product) string {
+	data, err := json.Marshal(product)
+	if err != nil {
+		slog.Error("Error encoding product for ETag", "error", err)
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using weak comparison as required for conditional GET
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProductID extracts and validates the productId path variable,
+// writing a 400 response and returning false when it is invalid
+func parseProductID(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	productIDStr := mux.Vars(r)["productId"]
+
+	productID64, err := strconv.ParseInt(productIDStr, 10, 32)
+	if err != nil || productID64 < 1 {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidProductID, "Invalid product ID format")
+		return 0, false
+	}
+	return int32(productID64), true
+}
+
+// decodeJSONBody strictly parses the size-limited request body into v, writing
+// a 415 for non-JSON content types, 413 when the body is too large, or 400 for
+// malformed JSON, and returning false on failure
+func (s *Server) decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	// Require application/json, allowing parameters such as charset
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeErrorResponse(w, r, http.StatusUnsupportedMediaType, ErrCodeUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	// Strict parsing catches typos; lenient parsing lets clients built against
+	// a newer API send fields this server doesn't know yet
+	if s.strictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, "Request body is required")
+			return false
+		}
+		writeDecodeError(w, r, err)
+		return false
+	}
+
+	// Reject trailing data such as a second JSON value after the first
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeDecodeError(w, r, err)
+			return false
+		}
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, "Invalid request body: must contain a single JSON value")
+		return false
+	}
+	return true
+}
+
+// unmarshalJSON maps data, already read by decodeJSONBody, onto v with the
+// same strictness, writing a 400 and returning false on failure
+func (s *Server) unmarshalJSON(w http.ResponseWriter, r *http.Request, data []byte, v interface{}) bool {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if s.strictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		writeDecodeError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// writeDecodeError maps a JSON decoding error onto a 413 or 400 response
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeErrorResponse(w, r, http.StatusRequestEntityTooLarge, ErrCodeBodyTooLarge, fmt.Sprintf("Request body too large: limit is %d bytes", maxBytesErr.Limit))
+		return
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Type == centsType {
+		// The decoder adds no field path to errors from Cents.UnmarshalJSON;
+		// price is the only amount clients send
+		if typeErr.Field == "" {
+			typeErr.Field = "price"
+		}
+		// A price in fractions of a cent is well-formed, just invalid
+		literal, isNumber := strings.CutPrefix(typeErr.Value, "number ")
+		if _, parseErr := ParseCents(literal); isNumber && errors.Is(parseErr, ErrCentsPrecision) {
+			writeValidationError(w, r, []FieldError{{Field: "price", Message: "price must have at most two decimal places"}})
+			return
+		}
+	}
+	writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, decodeErrorMessage(err))
+}
+
+// decodeErrorMessage describes a JSON decoding error in terms of the request,
+// naming the offending field or byte offset rather than Go types
+func decodeErrorMessage(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		want := jsonTypeName(typeErr.Type)
+		if typeErr.Field == "" {
+			return fmt.Sprintf("Invalid request body: must be %s", want)
+		}
+		field := jsonFieldPath(typeErr.Field)
+		// A whole number that doesn't fit is a range problem, not a type one
+		literal, isNumber := strings.CutPrefix(typeErr.Value, "number ")
+		if isNumber && (typeErr.Type == centsType || want == "an integer" && !strings.ContainsAny(literal, ".eE")) {
+			return fmt.Sprintf("Invalid request body: field '%s' is out of range", field)
+		}
+		return fmt.Sprintf("Invalid request body: field '%s' must be %s", field, want)
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("Invalid request body: malformed JSON at byte offset %d: %s", syntaxErr.Offset, syntaxErr)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "Invalid request body: JSON ends unexpectedly"
+	}
+	return fmt.Sprintf("Invalid request body: %s", strings.TrimPrefix(err.Error(), "json: "))
+}
+
+// jsonTypeName names the JSON type a Go value of type t is decoded from
+func jsonTypeName(t reflect.Type) string {
+	if t == centsType {
+		return "a number"
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// jsonFieldPath rewrites a decoder field path such as "2.price" to the
+// "[2].price" form used in validation errors
+func jsonFieldPath(path string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// decodeProduct parses a product from the request body and validates it,
+// first as sent and then as mapped onto product, writing an error response
+// and returning false on failure
+func (s *Server) decodeProduct(w http.ResponseWriter, r *http.Request, product *Product) bool {
+	var raw json.RawMessage
+	if !s.decodeJSONBody(w, r, &raw) {
+		return false
+	}
+	if fieldErrors := validateProductJSON(raw); len(fieldErrors) > 0 {
+		writeValidationError(w, r, fieldErrors)
+		return false
+	}
+	if !s.unmarshalJSON(w, r, raw, product) {
+		return false
+	}
+
+	if fieldErrors := s.validate(product); len(fieldErrors) > 0 {
+		writeValidationError(w, r, fieldErrors)
+		return false
+	}
+	return true
+}
+
+// parseNonNegativeInt parses an optional non-negative integer query value,
+// returning def when the value is empty
+func parseNonNegativeInt(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("value %d is negative", n)
+	}
+	return n, nil
+}
+
+// filterProductsByQuery applies the list filters given in query (category,
+// maxStock, inStock, minPrice/maxPrice, q) to products, returning an error for invalid
+// filter values. The q search is a case-insensitive substring match against
+// Name and Description; it is not fuzzy.
+func filterProductsByQuery(products []*Product, query url.Values) ([]*Product, error) {
+	if q := strings.ToLower(query.Get("q")); q != "" {
+		products = filterProducts(products, func(p *Product) bool {
+			return strings.Contains(strings.ToLower(p.Name), q) ||
+				strings.Contains(strings.ToLower(p.Description), q)
+		})
+	}
+	if category := query.Get("category"); category != "" {
+		products = filterProducts(products, func(p *Product) bool {
+			return p.inCategory(category)
+		})
+	}
+	if value := query.Get("maxStock"); value != "" {
+		maxStock, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || maxStock < 0 {
+			return nil, errors.New("Invalid maxStock: must be a non-negative integer")
+		}
+		products = filterProducts(products, func(p *Product) bool {
+			return int64(p.Stock) <= maxStock
+		})
+	}
+	if value := query.Get("inStock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("Invalid inStock: must be true or false")
+		}
+		products = filterProducts(products, func(p *Product) bool {
+			return (p.Stock > 0) == inStock
+		})
+	}
+
+	// Price range is inclusive on both ends and compared in exact cents
+	minPrice, err := parsePriceParam(query.Get("minPrice"), 0)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid minPrice: %v", err)
+	}
+	maxPrice, err := parsePriceParam(query.Get("maxPrice"), math.MaxInt64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid maxPrice: %v", err)
+	}
+	if minPrice > maxPrice {
+		return nil, errors.New("Invalid price range: minPrice must not exceed maxPrice")
+	}
+	if query.Get("minPrice") != "" || query.Get("maxPrice") != "" {
+		products = filterProducts(products, func(p *Product) bool {
+			return p.Price >= minPrice && p.Price <= maxPrice
+		})
+	}
+	return products, nil
+}
+
+// parsePriceParam parses an optional non-negative price query value,
+// returning def when the value is empty
+func parsePriceParam(value string, def Cents) (Cents, error) {
+	if value == "" {
+		return def, nil
+	}
+	price, err := ParseCents(value)
+	if errors.Is(err, ErrCentsPrecision) {
+		return 0, errors.New("must have at most two decimal places")
+	}
+	if err != nil || price < 0 {
+		return 0, errors.New("must be a non-negative number")
+	}
+	return price, nil
+}
+
+// filterProducts returns the products for which keep returns true, preserving order
+func filterProducts(products []*Product, keep func(*Product) bool) []*Product {
+	filtered := make([]*Product, 0, len(products))
+	for _, product := range products {
+		if keep(product) {
+			filtered = append(filtered, product)
+		}
+	}
+	return filtered
+}
+
+// paginate returns the window of products described by offset and limit
+func paginate(products []*Product, offset, limit int) []*Product {
+	if offset >= len(products) {
+		return []*Product{}
+	}
+	end := offset + limit
+	if end > len(products) {
+		end = len(products)
+	}
+	return products[offset:end]
+}
+
+// setPaginationHeaders sets X-Total-Count and an RFC 8288 Link header with
+// first/prev/next/last page URLs built from the request's own path and query,
+// so API prefixes and versions carry through
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, total, offset, limit int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if limit == 0 {
+		return
+	}
+
+	pageURL := func(pageOffset int) string {
+		u := *r.URL
+		query := u.Query()
+		query.Set("offset", strconv.Itoa(pageOffset))
+		query.Set("limit", strconv.Itoa(limit))
+		u.RawQuery = query.Encode()
+		return u.RequestURI()
+	}
+	lastOffset := 0
+	if total > 0 {
+		lastOffset = (total - 1) / limit * limit
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, pageURL(0))}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(prev)))
+	}
+	if offset+limit < total {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(offset+limit)))
+	}
+	links = append(links, fmt.Sprintf(`<%s>; rel="last"`, pageURL(lastOffset)))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// parseBoolQuery reads an optional boolean query parameter, defaulting to
+// false. It writes a 400 and returns false when the value is malformed.
+func parseBoolQuery(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, true
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, fmt.Sprintf("Invalid %s: must be true or false", name))
+		return false, false
+	}
+	return b, true
+}
+
+// parseIfMatch reads the expected product version from the If-Match header,
+// accepting a bare or quoted number ("3" or 3). It returns 0 when the header
+// is absent, and writes a 400 and returns false when it is malformed.
+func parseIfMatch(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.Header.Get("If-Match")
+	if value == "" {
+		return 0, true
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(value, "W/"), `"`))
+	if err != nil || version < 1 {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidParameter, "Invalid If-Match header: must be a product version number")
+		return 0, false
+	}
+	return version, true
+}
+
+// writeStoreError maps a store error for productID onto an HTTP error response
+func writeStoreError(w http.ResponseWriter, r *http.Request, productID int32, err error) {
+	switch {
+	case errors.Is(err, ErrProductNotFound):
+		writeErrorResponse(w, r, http.StatusNotFound, ErrCodeProductNotFound, fmt.Sprintf("Product with ID %d not found", productID))
+	case errors.Is(err, ErrInsufficientStock):
+		writeErrorResponse(w, r, http.StatusConflict, ErrCodeInsufficientStock, fmt.Sprintf("Insufficient stock for product %d", productID))
+	case errors.Is(err, ErrStockLimit):
+		writeErrorResponse(w, r, http.StatusConflict, ErrCodeStockLimitExceeded, fmt.Sprintf("Stock limit exceeded for product %d", productID))
+	case errors.Is(err, ErrVersionConflict):
+		writeErrorResponse(w, r, http.StatusConflict, ErrCodeVersionConflict, fmt.Sprintf("Product %d has been modified: version does not match If-Match", productID))
+	default:
+		slog.Error("Store operation failed", "id", productID, "error", err)
+		writeErrorResponse(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+	}
+}
+
+// xmlList wraps a slice so it encodes as a single XML document
+type xmlList struct {
+	XMLName xml.Name `xml:"items"`
+	Items   interface{}
+}
+
+// writeResponse writes v with the given status, encoded as XML when the
+// client's Accept header prefers it and as JSON otherwise. ?pretty=true
+// indents either format for human readers.
+func writeResponse(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	pretty := r.URL.Query().Get("pretty") == "true"
+	if prefersXML(r) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(statusCode)
+		if reflect.ValueOf(v).Kind() == reflect.Slice {
+			v = xmlList{Items: v}
+		}
+		io.WriteString(w, xml.Header)
+		encoder := xml.NewEncoder(w)
+		if pretty {
+			encoder.Indent("", "  ")
+		}
+		if err := encoder.Encode(v); err != nil {
+			slog.Error("Error encoding XML response", "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(v); err != nil {
+		slog.Error("Error encoding JSON response", "error", err)
+	}
+}
+
+// prefersXML reports whether the Accept header ranks XML above JSON.
+// JSON wins ties and is the default when no Accept header is sent.
+func prefersXML(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	xmlQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case "application/xml", "text/xml":
+			xmlQ = math.Max(xmlQ, q)
+		case "application/json", "*/*", "application/*":
+			jsonQ = math.Max(jsonQ, q)
+		}
+	}
+	return xmlQ > 0 && xmlQ > jsonQ
+}
+
+// writeErrorResponse writes an error response
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, statusCode int, code ErrorCode, message string) {
+	writeResponse(w, r, statusCode, Error{
+		Code:      statusCode,
+		ErrorCode: code,
+		Message:   message,
+	})
+}
+
+// writeValidationError writes a 422 response listing every invalid field
+func writeValidationError(w http.ResponseWriter, r *http.Request, fieldErrors []FieldError) {
+	writeResponse(w, r, http.StatusUnprocessableEntity, ValidationError{
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: ErrCodeValidationFailed,
+		Message:   "Validation failed",
+		Errors:    fieldErrors,
+	})
+}
+
+// requestIDKey is the context key under which the request ID is stored
+type requestIDKey struct{}
+
+// RequestIDMiddleware propagates the X-Request-ID header, generating one when absent
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the request ID stored on ctx, or "" if none
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// newRequestID generates a random UUID (version 4) string
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		slog.Error("Error generating request ID", "error", err)
+		return ""
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// responseRecorder wraps a ResponseWriter to capture the status code and body size
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records the status code before delegating
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.status = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write records the number of bytes written before delegating
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// AuthMiddleware requires a matching X-API-Key header on mutating requests
+// (POST/PUT/PATCH/DELETE); reads stay public
+func AuthMiddleware(apiKey string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				provided := r.Header.Get("X-API-Key")
+				if subtle.ConstantTimeCompare([]byte(provided), []byte(apiKey)) != 1 {
+					writeErrorResponse(w, r, http.StatusUnauthorized, ErrCodeUnauthorized, "Missing or invalid API key")
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// timeoutHeaderWriter labels the body http.TimeoutHandler writes on timeout as
+// JSON, and restores the Vary values set by outer middleware such as CORS,
+// which http.TimeoutHandler overwrites with the handler's own
+type timeoutHeaderWriter struct {
+	http.ResponseWriter
+	vary []string
+}
+
+// WriteHeader sets the JSON content type for timeout responses and merges the
+// outer Vary values back before delegating
+func (w timeoutHeaderWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	for _, v := range w.vary {
+		if !slices.Contains(w.Header().Values("Vary"), v) {
+			w.Header().Add("Vary", v)
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// TimeoutMiddleware bounds handler execution time, responding with 503 and the
+// standard Error body when exceeded. http.TimeoutHandler buffers the handler's
+// output, so nothing is written twice once the deadline passes. The event
+// stream is long-lived and unbuffered by design, and the product list streams
+// its items as it encodes them, so both are exempt; a stalled client is still
+// cut off by the server's WriteTimeout.
+func TimeoutMiddleware(timeout time.Duration) mux.MiddlewareFunc {
+	body, _ := json.Marshal(Error{
+		Code:      http.StatusServiceUnavailable,
+		ErrorCode: ErrCodeTimeout,
+		Message:   "Request timed out",
+	})
+	return func(next http.Handler) http.Handler {
+		th := http.TimeoutHandler(next, timeout, string(body))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := mux.CurrentRoute(r); route != nil && (route.GetName() == routeProductEvents || route.GetName() == routeListProducts) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			th.ServeHTTP(timeoutHeaderWriter{w, slices.Clone(w.Header().Values("Vary"))}, r)
+		})
+	}
+}
+
+// NotFoundHandler writes the standard JSON error for unknown paths
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeErrorResponse(w, r, http.StatusNotFound, ErrCodeRouteNotFound, fmt.Sprintf("Path %s not found", r.URL.Path))
+}
+
+// MethodNotAllowedHandler writes the standard JSON error with an Allow header
+// listing the methods the router supports for the requested path
+func MethodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(allowedMethods(router, r), ", "))
+		writeErrorResponse(w, r, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, fmt.Sprintf("Method %s not allowed on %s", r.Method, r.URL.Path))
+	})
+}
+
+// allowedMethods returns the methods router has a route for at r's path
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"} {
+		req := r.Clone(r.Context())
+		req.Method = method
+		var match mux.RouteMatch
+		if router.Match(req, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// LoggingMiddleware logs all incoming requests with their outcome and latency
+func LoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		slog.Info("HTTP request",
+			"method", r.Method,
+			"path", r.RequestURI,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"request_id", RequestIDFromContext(r.Context()),
+			"remote_addr", r.RemoteAddr,
+			"client_ip", clientIP(r),
+		)
+	})
+}
+
+// InFlightMiddleware counts the requests currently being handled, so shutdown
+// can report its progress draining them
+func (s *Server) InFlightMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// drain shuts srv down, waiting up to timeout for in-flight requests and
+// logging how many remain until they finish or the timeout elapses
+func (s *Server) drain(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(drainLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				slog.Info("Draining requests", "in_flight", s.inFlight.Load())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	slog.Info("Draining requests", "in_flight", s.inFlight.Load(), "timeout", timeout)
+	err := srv.Shutdown(ctx)
+	close(done)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("Shutdown timeout elapsed, abandoning requests", "in_flight", s.inFlight.Load())
+	} else if err != nil {
+		slog.Error("Server shutdown failed", "error", err)
+	}
+}
+
+// QueryLimitMiddleware rejects requests whose raw query string is longer than
+// maxBytes or carries more than maxParams parameters, before anything parses
+// it. A zero limit is not enforced.
+func QueryLimitMiddleware(maxBytes, maxParams int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.RawQuery
+			if maxBytes > 0 && len(query) > maxBytes {
+				writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeQueryTooLarge, fmt.Sprintf("Query string too large: limit is %d bytes", maxBytes))
+				return
+			}
+			if maxParams > 0 && query != "" && strings.Count(query, "&")+1 > maxParams {
+				writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeQueryTooLarge, fmt.Sprintf("Too many query parameters: limit is %d", maxParams))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RecoveryMiddleware handles panics gracefully. The stack trace is logged
+// server-side only; the response just carries the request ID for correlation,
+// plus the panic value when debugMode is set (DEBUG=true, for local development).
+func RecoveryMiddleware(debugMode bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					requestID := RequestIDFromContext(r.Context())
+					slog.Error("Panic recovered",
+						"error", err,
+						"request_id", requestID,
+						"stack", string(debug.Stack()),
+					)
+					message := "Internal server error"
+					if debugMode {
+						message = fmt.Sprintf("Internal server error: %v", err)
+					}
+					if requestID != "" {
+						message = fmt.Sprintf("%s (request ID: %s)", message, requestID)
+					}
+					writeErrorResponse(w, r, http.StatusInternalServerError, ErrCodeInternal, message)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// storeFromConfig creates the storage backend selected by cfg.StoreBackend
+// and a function to close it
+func storeFromConfig(cfg Config) (Store, func()) {
+	if cfg.StoreBackend != "sqlite" {
+		store := NewProductStore()
+		if cfg.NextIDStart > 1 {
+			store.SetFirstID(cfg.NextIDStart)
+		}
+		return store, func() {}
+	}
+	store, err := NewSQLiteStore(cfg.SQLitePath)
+	if err != nil {
+		log.Fatalf("Failed to open SQLite store at %s: %v", cfg.SQLitePath, err)
+	}
+	if cfg.NextIDStart > 1 {
+		if err := store.SetFirstID(cfg.NextIDStart); err != nil {
+			log.Fatalf("Failed to set SQLite store first ID: %v", err)
+		}
+	}
+	return store, func() {
+		if err := store.Close(); err != nil {
+			slog.Error("Error closing SQLite store", "error", err)
+		}
+	}
+}
+
+// CORSMiddleware adds CORS headers for browser clients and answers preflight
+// requests. A "*" entry in allowedOrigins allows any origin; otherwise the
+// request's Origin is echoed back only when it is in the list, and requests
+// from other origins get no Access-Control-Allow-Origin header.
+func CORSMiddleware(allowedOrigins []string) mux.MiddlewareFunc {
+	wildcard := slices.Contains(allowedOrigins, "*")
+	allowed := func(origin string) bool {
+		return slices.ContainsFunc(allowedOrigins, func(o string) bool { return strings.EqualFold(o, origin) })
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if wildcard {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				// The response depends on Origin, so caches must key on it
+				w.Header().Add("Vary", "Origin")
+				if origin := r.Header.Get("Origin"); origin != "" && allowed(origin) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, X-API-Key, Idempotency-Key")
+			w.Header().Set("Access-Control-Expose-Headers", "Link, X-Total-Count, Idempotent-Replayed")
+
+			// Short-circuit preflight requests
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// configureLogging installs the slog handler selected by LOG_FORMAT: "json"
+// for log aggregators, or "text" (the default) for the standard log format.
+// Records below LOG_LEVEL are dropped.
+func configureLogging(cfg Config) {
+	if cfg.LogFormat == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+		return
+	}
+	// Keep the default handler, which writes through the log package
+	slog.SetLogLoggerLevel(cfg.LogLevel)
+}
+
+func main() {
+	// Every setting is checked before anything starts, so a misconfigured
+	// deployment fails at once with all of its problems listed
+	cfg, err := LoadConfig(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	configureLogging(cfg)
+	slog.Info("Configuration", "config", cfg)
+	if cfg.APIKey == "" {
+		slog.Warn("API_KEY is not set, write endpoints are unauthenticated")
+	}
+	if cfg.Debug {
+		slog.Warn("DEBUG is enabled: panic messages are included in error responses")
+	}
+
+	// Create server, restoring persisted products when DATA_FILE is set
+	store, closeStore := storeFromConfig(cfg)
+	defer closeStore()
+	server := NewServer(store, cfg)
+	// Product routes answer 503 until the store has been loaded or seeded below
+	server.ready.Store(false)
+
+	// Reserved stock is returned automatically once RESERVATION_TTL elapses
+	stopExpiry := make(chan struct{})
+	defer close(stopExpiry)
+	go server.reservations.RunExpiry(stopExpiry)
+
+	// Create responses are replayed for retried Idempotency-Keys until IDEMPOTENCY_TTL elapses
+	stopPruning := make(chan struct{})
+	defer close(stopPruning)
+	go server.idempotency.RunPruning(stopPruning)
+
+	// Per-client rate limiting; idle buckets are evicted in the background
+	rateLimiter := NewRateLimiter(cfg.RateLimit)
+	stopEviction := make(chan struct{})
+	defer close(stopEviction)
+	go rateLimiter.RunEviction(stopEviction)
+
+	// Start server
+	slog.Info("Starting server", "port", cfg.Port, "api_prefix", cfg.APIPrefix)
+
+	// Explicit timeouts protect against slowloris-style clients
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           server.Handler(cfg, rateLimiter),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	// Open event streams never go idle, so end them when shutdown begins
+	srv.RegisterOnShutdown(server.events.Close)
+	go func() {
+		var err error
+		// TLS_CERT and TLS_KEY switch to HTTPS, which also negotiates HTTP/2
+		if cfg.TLSCert != "" {
+			err = srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	// Load or seed the store while already listening, so health checks pass
+	// and early product requests get a 503 with Retry-After
+	if err := initStore(store, cfg.DataFile, cfg.SeedData, cfg.SeedFile, cfg.Currency); err != nil {
+		log.Fatalf("Failed to initialize store: %v", err)
+	}
+	if server.names != nil {
+		server.names.Rebuild(server.store.ListProducts())
+	}
+	server.ready.Store(true)
+	slog.Info("Store initialized", "products", len(server.store.ListProducts()))
+
+	// Block until SIGINT/SIGTERM (ECS sends SIGTERM before stopping a task)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	// Let in-flight requests complete before exiting; anything arriving on a
+	// kept-alive connection meanwhile is turned away with a 503
+	slog.Info("Received shutdown signal, shutting down")
+	server.ready.Store(false)
+	server.drain(srv, cfg.ShutdownTimeout)
+	// Hand back stock held by unfinished checkouts so it isn't lost on restart
+	server.reservations.ReleaseAll()
+	if memStore, ok := store.(*ProductStore); ok && cfg.DataFile != "" {
+		if err := memStore.SaveToFile(cfg.DataFile); err != nil {
+			slog.Error("Error saving data file", "path", cfg.DataFile, "error", err)
+		} else {
+			slog.Info("Saved products", "path", cfg.DataFile)
+		}
+	}
+	slog.Info("Server exited cleanly")
+}
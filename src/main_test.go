@@ -0,0 +1,1851 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// newTestServer returns a server over a store seeded with the default
+// products (Laptop, Mouse and Keyboard with IDs 1 to 3) and the full handler
+// serving it, built as main builds it. configure, if given, adjusts the
+// default configuration first.
+func newTestServer(t testing.TB, configure ...func(*Config)) (*Server, http.Handler) {
+	t.Helper()
+	cfg := DefaultConfig()
+	// Every test request comes from the same address
+	cfg.RateLimit = float64(rate.Inf)
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	store, closeStore := storeFromConfig(cfg)
+	t.Cleanup(closeStore)
+	server := NewServer(store, cfg)
+	if err := initStore(store, cfg.DataFile, cfg.SeedData, cfg.SeedFile, cfg.Currency); err != nil {
+		t.Fatalf("initializing store: %v", err)
+	}
+	if server.names != nil {
+		server.names.Rebuild(server.store.ListProducts())
+	}
+	return server, server.Handler(cfg, NewRateLimiter(cfg.RateLimit))
+}
+
+// serve sends a request through h and returns the recorded response. A
+// non-empty body is sent as JSON; header lists extra header names and values
+// in pairs.
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeBody decodes the JSON body of rec into a T
+func decodeBody[T any](t testing.TB, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding response body %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+// assertStatus fails the test unless rec has the wanted status
+func assertStatus(t testing.TB, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, want, rec.Body.String())
+	}
+}
+
+// assertError fails the test unless rec is an error response with the wanted
+// status and error code
+func assertError(t testing.TB, rec *httptest.ResponseRecorder, status int, code ErrorCode) {
+	t.Helper()
+	assertStatus(t, rec, status)
+	body := decodeBody[Error](t, rec)
+	if body.Code != status || body.ErrorCode != code {
+		t.Fatalf("error = %d %s, want %d %s; message: %s", body.Code, body.ErrorCode, status, code, body.Message)
+	}
+}
+
+// getProduct fetches product id through h, failing the test unless it exists
+func getProduct(t testing.TB, h http.Handler, id string) Product {
+	t.Helper()
+	rec := serve(h, "GET", "/v1/products/"+id, "")
+	assertStatus(t, rec, http.StatusOK)
+	return decodeBody[Product](t, rec)
+}
+
+// createProduct adds product straight to store, failing the test on a store error
+func createProduct(t testing.TB, store Store, product *Product) *Product {
+	t.Helper()
+	created, err := store.CreateProduct(product)
+	if err != nil {
+		t.Fatalf("creating %q: %v", product.Name, err)
+	}
+	return created
+}
+
+func TestHandleGetProduct(t *testing.T) {
+	_, h := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantCode   ErrorCode
+		wantName   string
+	}{
+		{"found", "/v1/products/1", http.StatusOK, "", "Laptop"},
+		{"another product", "/v1/products/3", http.StatusOK, "", "Keyboard"},
+		{"not found", "/v1/products/99", http.StatusNotFound, ErrCodeProductNotFound, ""},
+		{"zero id", "/v1/products/0", http.StatusBadRequest, ErrCodeInvalidProductID, ""},
+		{"id out of range", "/v1/products/99999999999", http.StatusBadRequest, ErrCodeInvalidProductID, ""},
+		{"non-numeric id", "/v1/products/abc", http.StatusNotFound, ErrCodeRouteNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, "GET", tt.target, "")
+			if tt.wantCode != "" {
+				assertError(t, rec, tt.wantStatus, tt.wantCode)
+				return
+			}
+			assertStatus(t, rec, tt.wantStatus)
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			product := decodeBody[Product](t, rec)
+			if product.Name != tt.wantName {
+				t.Errorf("name = %q, want %q", product.Name, tt.wantName)
+			}
+			if rec.Header().Get("ETag") == "" {
+				t.Error("missing ETag header")
+			}
+		})
+	}
+}
+
+func TestHandleAddProductDetails(t *testing.T) {
+	const valid = `{"name":"Gaming Laptop","description":"Faster","price":1299.5,"stock":4,"category":"Electronics"}`
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+		wantCode   ErrorCode
+		// check inspects the store afterwards when the update is accepted
+		check func(t *testing.T, h http.Handler)
+	}{
+		{
+			name: "valid", target: "/v1/products/1/details", body: valid,
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, h http.Handler) {
+				product := getProduct(t, h, "1")
+				if product.Name != "Gaming Laptop" || product.Price != 129950 || product.Stock != 4 || product.Version != 2 {
+					t.Errorf("stored product = %+v", product)
+				}
+			},
+		},
+		{
+			// The path decides which product is written; a body ID is ignored
+			name: "id mismatch", target: "/v1/products/1/details",
+			body:       `{"id":2,"name":"Gaming Laptop","price":1299.5,"stock":4}`,
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, h http.Handler) {
+				if product := getProduct(t, h, "1"); product.ID != 1 || product.Name != "Gaming Laptop" {
+					t.Errorf("product 1 = %+v", product)
+				}
+				if product := getProduct(t, h, "2"); product.Name != "Mouse" || product.Version != 1 {
+					t.Errorf("product 2 was modified: %+v", product)
+				}
+			},
+		},
+		{name: "malformed json", target: "/v1/products/1/details", body: `{"name":`, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidBody},
+		{name: "wrong field type", target: "/v1/products/1/details", body: `{"name":"Laptop","price":"cheap","stock":1}`, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidBody},
+		{name: "unknown field", target: "/v1/products/1/details", body: `{"name":"Laptop","price":1,"stock":1,"colour":"red"}`, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidBody},
+		{name: "missing name", target: "/v1/products/1/details", body: `{"price":1,"stock":1}`, wantStatus: http.StatusUnprocessableEntity, wantCode: ErrCodeValidationFailed},
+		{name: "bad id", target: "/v1/products/0/details", body: valid, wantStatus: http.StatusBadRequest, wantCode: ErrCodeInvalidProductID},
+		{name: "not found", target: "/v1/products/99/details", body: valid, wantStatus: http.StatusNotFound, wantCode: ErrCodeProductNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := serve(h, "POST", tt.target, tt.body)
+			if tt.wantCode != "" {
+				assertError(t, rec, tt.wantStatus, tt.wantCode)
+				if product := getProduct(t, h, "1"); product.Version != 1 {
+					t.Errorf("product 1 was modified by a rejected request: %+v", product)
+				}
+				return
+			}
+			assertStatus(t, rec, tt.wantStatus)
+			if rec.Body.Len() != 0 {
+				t.Errorf("body = %q, want empty", rec.Body.String())
+			}
+			tt.check(t, h)
+		})
+	}
+}
+
+func TestHandleHealth(t *testing.T) {
+	_, h := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"store check", "/health", http.StatusOK, `{"status":"ok","store":"ok"}` + "\n"},
+		{"simple", "/health?simple=true", http.StatusOK, "OK"},
+		{"invalid simple", "/health?simple=maybe", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, "GET", tt.target, "")
+			if tt.wantStatus != http.StatusOK {
+				assertError(t, rec, tt.wantStatus, ErrCodeInvalidParameter)
+				return
+			}
+			assertStatus(t, rec, tt.wantStatus)
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandleHealthStore(t *testing.T) {
+	configure := storeBackends(t)["sqlite"]
+	server, h := newTestServer(t, configure)
+	rec := serve(h, "GET", "/health", "")
+	assertStatus(t, rec, http.StatusOK)
+	if health := decodeBody[HealthStatus](t, rec); health.Status != "ok" || health.Store != "ok" {
+		t.Errorf("healthy store reported as %+v", health)
+	}
+
+	// Closing the database makes it unreachable, as a lost connection would
+	server.store.(*observedStore).Store.(*SQLiteStore).Close()
+	rec = serve(h, "GET", "/health", "")
+	assertStatus(t, rec, http.StatusServiceUnavailable)
+	if health := decodeBody[HealthStatus](t, rec); health.Status != "unavailable" || health.Store != "unavailable" || health.Error == "" {
+		t.Errorf("unreachable store reported as %+v", health)
+	}
+
+	// Liveness doesn't depend on the store
+	rec = serve(h, "GET", "/health?simple=true", "")
+	assertStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "OK" {
+		t.Errorf("simple body = %q, want OK", rec.Body.String())
+	}
+}
+
+// storeBackends returns a configuration function for each storage backend,
+// for tests that must pass against both
+func storeBackends(t *testing.T) map[string]func(*Config) {
+	return map[string]func(*Config){
+		"memory": func(*Config) {},
+		"sqlite": func(cfg *Config) {
+			cfg.StoreBackend = "sqlite"
+			cfg.SQLitePath = filepath.Join(t.TempDir(), "store.db")
+		},
+	}
+}
+
+func TestHandlePurchase(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+
+			rec := serve(h, "POST", "/v1/products/1/purchase", `{"quantity":3}`)
+			assertStatus(t, rec, http.StatusOK)
+			product := decodeBody[Product](t, rec)
+			if product.ID != 1 || product.Stock != 7 || product.Version != 2 {
+				t.Errorf("purchased product = %+v, want ID 1 with stock 7 at version 2", product)
+			}
+
+			assertError(t, serve(h, "POST", "/v1/products/1/purchase", `{"quantity":8}`), http.StatusConflict, ErrCodeInsufficientStock)
+			assertError(t, serve(h, "POST", "/v1/products/99/purchase", `{"quantity":1}`), http.StatusNotFound, ErrCodeProductNotFound)
+			if product := getProduct(t, h, "1"); product.Stock != 7 {
+				t.Errorf("stock after rejected purchases = %d, want 7", product.Stock)
+			}
+		})
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	padded := func(n int) string {
+		return `{"name":"Laptop","description":"` + strings.Repeat("x", n) + `","price":1,"stock":1}`
+	}
+	tests := []struct {
+		name       string
+		maxBytes   int64
+		body       string
+		wantStatus int
+	}{
+		{"default limit exceeded", defaultMaxBodyBytes, padded(defaultMaxBodyBytes), http.StatusRequestEntityTooLarge},
+		{"configured limit exceeded", 64, padded(64), http.StatusRequestEntityTooLarge},
+		// Trailing whitespace past the limit still has to be read to
+		// rule out a second value
+		{"limit exceeded after the value", 64, `{"name":"Laptop","price":1,"stock":1}` + strings.Repeat(" ", 64), http.StatusRequestEntityTooLarge},
+		{"within configured limit", 64, `{"name":"Laptop","price":1,"stock":1}`, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) { cfg.MaxBodyBytes = tt.maxBytes })
+			rec := serve(h, "POST", "/v1/products/1/details", tt.body)
+			if tt.wantStatus == http.StatusRequestEntityTooLarge {
+				assertError(t, rec, tt.wantStatus, ErrCodeBodyTooLarge)
+				return
+			}
+			assertStatus(t, rec, tt.wantStatus)
+		})
+	}
+}
+
+// productIDs returns the IDs of products in order
+func productIDs(products []*Product) []int32 {
+	ids := make([]int32, 0, len(products))
+	for _, product := range products {
+		ids = append(ids, product.ID)
+	}
+	return ids
+}
+
+func TestListProductsSearch(t *testing.T) {
+	server, h := newTestServer(t)
+	createProduct(t, server.store, &Product{Name: "Laptop Stand", Description: "Aluminium riser", Price: 3999, Currency: "USD", Stock: 5, Category: "Accessories"})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantIDs   []int32
+		wantTotal int
+	}{
+		{"name match", "q=lap", []int32{1, 4}, 2},
+		{"case-insensitive", "q=LAP", []int32{1, 4}, 2},
+		{"description-only match", "q=wireless", []int32{2}, 1},
+		{"substring within a word", "q=chanic", []int32{3}, 1},
+		{"no match", "q=lapptop", []int32{}, 0},
+		{"empty q lists everything", "q=", []int32{1, 2, 3, 4}, 4},
+		{"with category", "q=lap&category=Accessories", []int32{4}, 1},
+		{"with pagination", "q=lap&limit=1&offset=1", []int32{4}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, "GET", "/v1/products?"+tt.query, "")
+			assertStatus(t, rec, http.StatusOK)
+			page := decodeBody[ProductPage](t, rec)
+			if ids := productIDs(page.Items); !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("IDs = %v, want %v", ids, tt.wantIDs)
+			}
+			if page.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", page.Total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+// assertFieldError fails the test unless rec is a 422 validation error naming
+// field
+func assertFieldError(t testing.TB, rec *httptest.ResponseRecorder, field string) {
+	t.Helper()
+	assertStatus(t, rec, http.StatusUnprocessableEntity)
+	body := decodeBody[ValidationError](t, rec)
+	if body.ErrorCode != ErrCodeValidationFailed {
+		t.Fatalf("errorCode = %s, want %s", body.ErrorCode, ErrCodeValidationFailed)
+	}
+	for _, fe := range body.Errors {
+		if fe.Field == field {
+			return
+		}
+	}
+	t.Fatalf("no error for field %q in %+v", field, body.Errors)
+}
+
+// captureLogs sends slog output to the returned buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		debug       bool
+		value       any // what the handler panics with
+		wantMessage string
+	}{
+		{"production", false, "handler exploded", "Internal server error (request ID: req-123)"},
+		{"production error value", false, errors.New("handler exploded"), "Internal server error (request ID: req-123)"},
+		{"debug", true, "handler exploded", "Internal server error: handler exploded (request ID: req-123)"},
+		{"debug error value", true, errors.New("handler exploded"), "Internal server error: handler exploded (request ID: req-123)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic(tt.value)
+			})
+			h := RequestIDMiddleware(RecoveryMiddleware(tt.debug)(panicking))
+
+			rec := serve(h, "GET", "/v1/products/1", "", "X-Request-ID", "req-123")
+			assertError(t, rec, http.StatusInternalServerError, ErrCodeInternal)
+			if body := decodeBody[Error](t, rec); body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+			for _, leak := range []string{"goroutine", ".go:", "runtime/debug"} {
+				if strings.Contains(rec.Body.String(), leak) {
+					t.Errorf("stack trace leaked into the response: %s", rec.Body.String())
+				}
+			}
+
+			logged := logs.String()
+			for _, want := range []string{"Panic recovered", "handler exploded", "request_id=req-123", "stack=", "TestRecoveryMiddleware"} {
+				if !strings.Contains(logged, want) {
+					t.Errorf("log is missing %q:\n%s", want, logged)
+				}
+			}
+		})
+	}
+
+	// Detail is opt-in through DEBUG
+	for value, want := range map[string]bool{"": false, "false": false, "true": true} {
+		cfg, err := LoadConfig(func(name string) string {
+			if name == "DEBUG" {
+				return value
+			}
+			return ""
+		})
+		if err != nil || cfg.Debug != want {
+			t.Errorf("DEBUG=%q: Debug = %v, %v; want %v", value, cfg.Debug, err, want)
+		}
+	}
+}
+
+func TestContentTypeEnforcement(t *testing.T) {
+	const body = `{"name":"Widget","price":1,"stock":1}`
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		wantStatus  int
+	}{
+		{"text/plain details", "POST", "/v1/products/1/details", "text/plain", http.StatusUnsupportedMediaType},
+		{"form details", "POST", "/v1/products/1/details", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"missing content type", "POST", "/v1/products/1/details", "", http.StatusUnsupportedMediaType},
+		{"text/plain create", "POST", "/v1/products", "text/plain", http.StatusUnsupportedMediaType},
+		{"text/plain replace", "PUT", "/v1/products/1", "text/plain", http.StatusUnsupportedMediaType},
+		{"json with charset", "POST", "/v1/products/1/details", "application/json; charset=utf-8", http.StatusNoContent},
+		{"json in upper case", "PUT", "/v1/products/1", "Application/JSON", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := serve(h, tt.method, tt.target, body, "Content-Type", tt.contentType)
+			if tt.wantStatus == http.StatusUnsupportedMediaType {
+				assertError(t, rec, tt.wantStatus, ErrCodeUnsupportedMediaType)
+				return
+			}
+			assertStatus(t, rec, tt.wantStatus)
+		})
+	}
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	const body = `{"name":"Laptop Pro","price":1,"stock":1}`
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		ifMatch     string
+		wantStatus  int
+		wantCode    ErrorCode
+		wantVersion int
+	}{
+		{"replace without If-Match", "PUT", "/v1/products/1", "", http.StatusOK, "", 2},
+		{"replace with current version", "PUT", "/v1/products/1", "1", http.StatusOK, "", 2},
+		{"replace with quoted version", "PUT", "/v1/products/1", `"1"`, http.StatusOK, "", 2},
+		{"replace with stale version", "PUT", "/v1/products/1", "2", http.StatusConflict, ErrCodeVersionConflict, 1},
+		{"details with current version", "POST", "/v1/products/1/details", "1", http.StatusNoContent, "", 2},
+		{"details with stale version", "POST", "/v1/products/1/details", "7", http.StatusConflict, ErrCodeVersionConflict, 1},
+		{"malformed If-Match", "PUT", "/v1/products/1", "latest", http.StatusBadRequest, ErrCodeInvalidParameter, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			var header []string
+			if tt.ifMatch != "" {
+				header = []string{"If-Match", tt.ifMatch}
+			}
+			rec := serve(h, tt.method, tt.target, body, header...)
+			if tt.wantCode != "" {
+				assertError(t, rec, tt.wantStatus, tt.wantCode)
+			} else {
+				assertStatus(t, rec, tt.wantStatus)
+			}
+			if product := getProduct(t, h, "1"); product.Version != tt.wantVersion {
+				t.Errorf("version = %d, want %d", product.Version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestIfMatchSequentialWrites(t *testing.T) {
+	_, h := newTestServer(t)
+	// Two clients read version 1; only the first write based on it succeeds
+	first := serve(h, "PUT", "/v1/products/2", `{"name":"Mouse v2","price":1,"stock":1}`, "If-Match", "1")
+	assertStatus(t, first, http.StatusOK)
+	if product := decodeBody[Product](t, first); product.Version != 2 {
+		t.Errorf("version after write = %d, want 2", product.Version)
+	}
+	second := serve(h, "PUT", "/v1/products/2", `{"name":"Mouse v2b","price":1,"stock":1}`, "If-Match", "1")
+	assertError(t, second, http.StatusConflict, ErrCodeVersionConflict)
+	if product := getProduct(t, h, "2"); product.Name != "Mouse v2" {
+		t.Errorf("name = %q, want the first write's", product.Name)
+	}
+}
+
+func TestGetProductContentNegotiation(t *testing.T) {
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+	}{
+		{"no Accept", "", "application/json"},
+		{"json", "application/json", "application/json"},
+		{"any", "*/*", "application/json"},
+		{"xml", "application/xml", "application/xml"},
+		{"text/xml", "text/xml", "application/xml"},
+		{"xml preferred by q", "application/json;q=0.5, application/xml", "application/xml"},
+		{"json preferred by q", "application/xml;q=0.5, application/json", "application/json"},
+		{"xml refused", "application/xml;q=0", "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := serve(h, "GET", "/v1/products/1", "", "Accept", tt.accept)
+			assertStatus(t, rec, http.StatusOK)
+			if got := rec.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+
+			if tt.wantContentType == "application/json" {
+				if product := decodeBody[Product](t, rec); product.ID != 1 || product.Name != "Laptop" || product.Price != 99999 {
+					t.Errorf("product = %+v", product)
+				}
+				return
+			}
+			var product struct {
+				XMLName xml.Name `xml:"product"`
+				ID      int32    `xml:"id"`
+				Name    string   `xml:"name"`
+				Price   string   `xml:"price"`
+				Stock   int32    `xml:"stock"`
+			}
+			if err := xml.Unmarshal(rec.Body.Bytes(), &product); err != nil {
+				t.Fatalf("decoding XML %q: %v", rec.Body.String(), err)
+			}
+			if product.ID != 1 || product.Name != "Laptop" || product.Price != "999.99" || product.Stock != 10 {
+				t.Errorf("product = %+v", product)
+			}
+		})
+	}
+}
+
+func TestErrorContentNegotiation(t *testing.T) {
+	_, h := newTestServer(t)
+	rec := serve(h, "GET", "/v1/products/99", "", "Accept", "application/xml")
+	assertStatus(t, rec, http.StatusNotFound)
+	if got := rec.Header().Get("Content-Type"); got != "application/xml" {
+		t.Fatalf("Content-Type = %q, want application/xml", got)
+	}
+	var body Error
+	if err := xml.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding XML %q: %v", rec.Body.String(), err)
+	}
+	if body.Code != http.StatusNotFound || body.ErrorCode != ErrCodeProductNotFound {
+		t.Errorf("error = %+v", body)
+	}
+}
+
+func TestHandleResetProducts(t *testing.T) {
+	_, h := newTestServer(t)
+	const body = `{"name":"Widget","price":1,"stock":1}`
+	assertStatus(t, serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, "create-widget"), http.StatusCreated)
+
+	rec := serve(h, "DELETE", "/v1/products", "", "X-Request-ID", "reset-1")
+	assertStatus(t, rec, http.StatusNoContent)
+	if page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", "")); page.Total != 0 {
+		t.Fatalf("total after reset = %d, want 0", page.Total)
+	}
+
+	entries := decodeBody[[]AuditEntry](t, serve(h, "GET", "/v1/audit", ""))
+	if len(entries) == 0 || entries[0].Operation != AuditReset || entries[0].ProductID != 0 || entries[0].RequestID != "reset-1" {
+		t.Errorf("latest audit entry = %+v, want the reset", entries)
+	}
+
+	// A retried create must not replay a response for a product the reset removed
+	rec = serve(h, "POST", "/v1/products", body, idempotencyKeyHeader, "create-widget")
+	assertStatus(t, rec, http.StatusCreated)
+	if page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", "")); page.Total != 1 {
+		t.Errorf("total after retried create = %d, want 1", page.Total)
+	}
+}
+
+func TestDecodeJSONBodyShape(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantMessage string
+	}{
+		{"empty body", "", "Request body is required"},
+		{"whitespace only", "  \n", "Request body is required"},
+		{"two objects", `{"name":"A","price":1,"stock":1}{"name":"B","price":1,"stock":1}`, "Invalid request body: must contain a single JSON value"},
+		{"trailing garbage", `{"name":"A","price":1,"stock":1} x`, "Invalid request body: must contain a single JSON value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			// serve only labels non-empty bodies as JSON
+			rec := serve(h, "POST", "/v1/products/1/details", tt.body, "Content-Type", "application/json")
+			assertError(t, rec, http.StatusBadRequest, ErrCodeInvalidBody)
+			if body := decodeBody[Error](t, rec); body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+		})
+	}
+
+	// Trailing whitespace after a single value is fine
+	_, h := newTestServer(t)
+	assertStatus(t, serve(h, "POST", "/v1/products/1/details", "{\"name\":\"A\",\"price\":1,\"stock\":1}\n\n"), http.StatusNoContent)
+}
+
+func TestHandleStats(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			stats := decodeBody[StoreStats](t, serve(h, "GET", "/stats", ""))
+			if want := (StoreStats{Products: 3, TotalStock: 90, Categories: 1, NextID: 4}); stats != want {
+				t.Errorf("seeded stats = %+v, want %+v", stats, want)
+			}
+
+			assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Novel","price":12.5,"stock":5,"category":"Books","categories":["Fiction"]}`), http.StatusCreated)
+			assertStatus(t, serve(h, "DELETE", "/v1/products/2", ""), http.StatusNoContent)
+			stats = decodeBody[StoreStats](t, serve(h, "GET", "/stats", ""))
+			// The deleted Mouse is counted apart; the Novel adds two categories
+			if want := (StoreStats{Products: 3, Deleted: 1, TotalStock: 45, Categories: 3, NextID: 5}); stats != want {
+				t.Errorf("stats = %+v, want %+v", stats, want)
+			}
+		})
+	}
+}
+
+// parseLinks maps each rel of an RFC 8288 Link header to its URL
+func parseLinks(t *testing.T, header string) map[string]string {
+	t.Helper()
+	links := make(map[string]string)
+	if header == "" {
+		return links
+	}
+	for _, link := range strings.Split(header, ", ") {
+		target, rel, ok := strings.Cut(link, "; rel=")
+		if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			t.Fatalf("malformed link %q in %q", link, header)
+		}
+		links[strings.Trim(rel, `"`)] = strings.Trim(target, "<>")
+	}
+	return links
+}
+
+func TestPaginationLinks(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		target    string
+		wantLinks map[string]string
+	}{
+		{"first page", "", "/v1/products?limit=1", map[string]string{
+			"first": "/v1/products?limit=1&offset=0",
+			"next":  "/v1/products?limit=1&offset=1",
+			"last":  "/v1/products?limit=1&offset=2",
+		}},
+		{"middle page", "", "/v1/products?limit=1&offset=1", map[string]string{
+			"first": "/v1/products?limit=1&offset=0",
+			"prev":  "/v1/products?limit=1&offset=0",
+			"next":  "/v1/products?limit=1&offset=2",
+			"last":  "/v1/products?limit=1&offset=2",
+		}},
+		{"last page", "", "/v1/products?limit=1&offset=2", map[string]string{
+			"first": "/v1/products?limit=1&offset=0",
+			"prev":  "/v1/products?limit=1&offset=1",
+			"last":  "/v1/products?limit=1&offset=2",
+		}},
+		{"single page", "", "/v1/products?limit=10", map[string]string{
+			"first": "/v1/products?limit=10&offset=0",
+			"last":  "/v1/products?limit=10&offset=0",
+		}},
+		{"prefix and filters carry through", "/api", "/api/v1/products?category=Electronics&limit=2", map[string]string{
+			"first": "/api/v1/products?category=Electronics&limit=2&offset=0",
+			"next":  "/api/v1/products?category=Electronics&limit=2&offset=2",
+			"last":  "/api/v1/products?category=Electronics&limit=2&offset=2",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) { cfg.APIPrefix = tt.prefix })
+			rec := serve(h, "GET", tt.target, "")
+			assertStatus(t, rec, http.StatusOK)
+			if got := rec.Header().Get("X-Total-Count"); got != "3" {
+				t.Errorf("X-Total-Count = %q, want 3", got)
+			}
+			if links := parseLinks(t, rec.Header().Get("Link")); !maps.Equal(links, tt.wantLinks) {
+				t.Errorf("links = %v, want %v", links, tt.wantLinks)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	const body = `{"name":"Widget","price":4.5,"stock":2}`
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		wantID      int32
+		wantVersion int
+	}{
+		{"create", "POST", "/v1/products?dryRun=true", 0, 1},
+		{"replace", "PUT", "/v1/products/1?dryRun=true", 1, 2},
+		{"details", "POST", "/v1/products/1/details?dryRun=true", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := serve(h, tt.method, tt.target, body)
+			assertStatus(t, rec, http.StatusOK)
+			preview := decodeBody[Product](t, rec)
+			if preview.ID != tt.wantID || preview.Version != tt.wantVersion || preview.Name != "Widget" || preview.Price != 450 || preview.Currency != "USD" {
+				t.Errorf("preview = %+v", preview)
+			}
+
+			// Nothing was written
+			page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", ""))
+			if page.Total != 3 {
+				t.Errorf("total = %d, want 3", page.Total)
+			}
+			if product := getProduct(t, h, "1"); product.Name != "Laptop" || product.Version != 1 {
+				t.Errorf("product 1 = %+v, want it unchanged", product)
+			}
+			if entries := decodeBody[[]AuditEntry](t, serve(h, "GET", "/v1/audit", "")); len(entries) != 0 {
+				t.Errorf("audit entries = %+v, want none", entries)
+			}
+		})
+	}
+}
+
+func TestDryRunRejections(t *testing.T) {
+	_, h := newTestServer(t)
+	assertFieldError(t, serve(h, "POST", "/v1/products?dryRun=true", `{"price":1,"stock":1}`), "name")
+	assertError(t, serve(h, "PUT", "/v1/products/99?dryRun=true", `{"name":"Widget","price":1,"stock":1}`), http.StatusNotFound, ErrCodeProductNotFound)
+	assertError(t, serve(h, "PUT", "/v1/products/1?dryRun=true", `{"name":"Widget","price":1,"stock":1}`, "If-Match", "5"), http.StatusConflict, ErrCodeVersionConflict)
+	assertError(t, serve(h, "POST", "/v1/products?dryRun=maybe", `{"name":"Widget","price":1,"stock":1}`), http.StatusBadRequest, ErrCodeInvalidParameter)
+}
+
+func TestSparseFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   string
+		wantBody string // "" when the request is rejected
+	}{
+		{"subset", "id,name,price", `{"id":1,"name":"Laptop","price":999.99}`},
+		{"single field", "stock", `{"stock":10}`},
+		{"spaces around names", "id, name", `{"id":1,"name":"Laptop"}`},
+		{"empty optional field stays omitted", "id,imageUrl", `{"id":1}`},
+		{"unknown field", "id,colour", ""},
+		{"case matters", "ID", ""},
+		{"empty entry", "id,,name", ""},
+	}
+	_, h := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, "GET", "/v1/products/1?fields="+url.QueryEscape(tt.fields), "")
+			if tt.wantBody == "" {
+				assertError(t, rec, http.StatusBadRequest, ErrCodeInvalidParameter)
+				return
+			}
+			assertStatus(t, rec, http.StatusOK)
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+
+	rec := serve(h, "GET", "/v1/products/1?fields=id", "", "Accept", "application/xml")
+	assertStatus(t, rec, http.StatusNotAcceptable)
+}
+
+func TestPrettyPrint(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		indent string // expected start of the second line; "" for compact output
+	}{
+		{"product compact", "/v1/products/1", "", ""},
+		{"product pretty", "/v1/products/1?pretty=true", "", `  "id": 1,`},
+		{"list pretty", "/v1/products?pretty=true", "", `  "items": [`},
+		{"error pretty", "/v1/products/99?pretty=true", "", `  "code": 404,`},
+		{"stats pretty", "/stats?pretty=true", "", `  "products": 3,`},
+		{"xml pretty", "/v1/products/1?pretty=true", "application/xml", "<product>"},
+		{"only true enables it", "/v1/products/1?pretty=1", "", ""},
+	}
+	// With the cache on, pretty output must bypass the cached compact body
+	_, h := newTestServer(t, func(cfg *Config) { cfg.CacheTTL = time.Minute })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, "GET", tt.target, "", "Accept", tt.accept)
+			lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+			if tt.indent == "" {
+				if len(lines) != 1 {
+					t.Errorf("compact body spans %d lines:\n%s", len(lines), rec.Body.String())
+				}
+				return
+			}
+			if len(lines) < 2 || lines[1] != tt.indent {
+				t.Errorf("body is not indented as expected, want second line %q:\n%s", tt.indent, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	_, h := newTestServer(t)
+	const old = "Wed, 01 Jan 2025 10:00:00 GMT"
+	rec := serve(h, "POST", "/v1/import", `{"nextId":2,"products":[{"id":1,"name":"Laptop","price":1,"stock":1,"createdAt":"2025-01-01T10:00:00Z","updatedAt":"2025-01-01T10:00:00.5Z"}]}`)
+	assertStatus(t, rec, http.StatusOK)
+
+	// A fresh fetch reports when the product last changed
+	rec = serve(h, "GET", "/v1/products/1", "")
+	assertStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Last-Modified"); got != old {
+		t.Fatalf("Last-Modified = %q, want %q", got, old)
+	}
+
+	tests := []struct {
+		name       string
+		since      string
+		wantStatus int
+	}{
+		{"unchanged since Last-Modified", old, http.StatusNotModified},
+		{"unchanged since a later time", "Thu, 02 Jan 2025 10:00:00 GMT", http.StatusNotModified},
+		{"changed since an earlier time", "Wed, 01 Jan 2025 09:59:59 GMT", http.StatusOK},
+		{"malformed date is ignored", "yesterday", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, "GET", "/v1/products/1", "", "If-Modified-Since", tt.since)
+			assertStatus(t, rec, tt.wantStatus)
+			if tt.wantStatus == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("304 has a body: %q", rec.Body.String())
+			}
+		})
+	}
+
+	// After a write the old date no longer matches
+	assertStatus(t, serve(h, "PUT", "/v1/products/1", `{"name":"Laptop","price":2,"stock":1}`), http.StatusOK)
+	rec = serve(h, "GET", "/v1/products/1", "", "If-Modified-Since", old)
+	assertStatus(t, rec, http.StatusOK)
+	modified, err := http.ParseTime(rec.Header().Get("Last-Modified"))
+	if err != nil || !modified.After(time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Last-Modified after the write = %q, want a later time", rec.Header().Get("Last-Modified"))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	etag := rec.Header().Get("ETag")
+	assertStatus(t, serve(h, "GET", "/v1/products/1", "", "If-None-Match", `"stale"`, "If-Modified-Since", time.Now().UTC().Add(time.Hour).Format(http.TimeFormat)), http.StatusOK)
+	assertStatus(t, serve(h, "GET", "/v1/products/1", "", "If-None-Match", etag), http.StatusNotModified)
+}
+
+func TestErrorCodes(t *testing.T) {
+	const product = `{"name":"Widget","price":1,"stock":1}`
+	batch := "[" + strings.TrimSuffix(strings.Repeat(product+",", maxBatchSize+1), ",") + "]"
+
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		prepare   func(*Server, http.Handler)
+		method    string
+		target    string
+		body      string
+		header    []string
+		status    int
+		code      string // the literal string, so renaming a constant breaks the test
+	}{
+		{name: "bad product id", method: "GET", target: "/v1/products/0", status: 400, code: "INVALID_PRODUCT_ID"},
+		{name: "bad query parameter", method: "GET", target: "/v1/products?limit=-1", status: 400, code: "INVALID_PARAMETER"},
+		{name: "malformed body", method: "POST", target: "/v1/products", body: `{`, status: 400, code: "INVALID_BODY"},
+		{name: "zero quantity", method: "POST", target: "/v1/products/1/purchase", body: `{"quantity":0}`, status: 400, code: "INVALID_QUANTITY"},
+		{name: "invalid product", method: "POST", target: "/v1/products", body: `{"name":"","price":1,"stock":1}`, status: 422, code: "VALIDATION_FAILED"},
+		{name: "batch too large", method: "POST", target: "/v1/products/batch", body: batch, status: 400, code: "BATCH_TOO_LARGE"},
+		{name: "body too large", configure: func(cfg *Config) { cfg.MaxBodyBytes = 8 }, method: "POST", target: "/v1/products", body: product, status: 413, code: "BODY_TOO_LARGE"},
+		{name: "query too large", configure: func(cfg *Config) { cfg.MaxQueryParams = 1 }, method: "GET", target: "/v1/products?limit=1&offset=1", status: 400, code: "QUERY_TOO_LARGE"},
+		{name: "wrong content type", method: "POST", target: "/v1/products", body: product, header: []string{"Content-Type", "text/plain"}, status: 415, code: "UNSUPPORTED_MEDIA_TYPE"},
+		{name: "missing api key", configure: func(cfg *Config) { cfg.APIKey = "secret" }, method: "POST", target: "/v1/products", body: product, status: 401, code: "UNAUTHORIZED"},
+		{name: "missing product", method: "GET", target: "/v1/products/99", status: 404, code: "PRODUCT_NOT_FOUND"},
+		{name: "missing reservation", method: "DELETE", target: "/v1/reservations/nope", status: 404, code: "RESERVATION_NOT_FOUND"},
+		{name: "unknown route", method: "GET", target: "/nope", status: 404, code: "ROUTE_NOT_FOUND"},
+		{name: "wrong method", method: "PATCH", target: "/v1/products/1", body: product, status: 405, code: "METHOD_NOT_ALLOWED"},
+		{name: "insufficient stock", method: "POST", target: "/v1/products/1/purchase", body: `{"quantity":11}`, status: 409, code: "INSUFFICIENT_STOCK"},
+		{name: "stock limit", configure: func(cfg *Config) { cfg.MaxStock = 10 }, method: "POST", target: "/v1/products/1/stock/adjust", body: `{"delta":1}`, status: 409, code: "STOCK_LIMIT_EXCEEDED"},
+		{name: "product limit", configure: func(cfg *Config) { cfg.MaxProducts = 3 }, method: "POST", target: "/v1/products", body: product, status: 507, code: "PRODUCT_LIMIT_REACHED"},
+		{name: "stale version", method: "PUT", target: "/v1/products/1", body: product, header: []string{"If-Match", "9"}, status: 409, code: "VERSION_CONFLICT"},
+		{name: "duplicate name", configure: func(cfg *Config) { cfg.PreventDuplicateNames = true }, method: "POST", target: "/v1/products", body: `{"name":"Laptop","price":1,"stock":1}`, status: 409, code: "DUPLICATE_NAME"},
+		{
+			name: "idempotency key reused",
+			prepare: func(_ *Server, h http.Handler) {
+				serve(h, "POST", "/v1/products", product, idempotencyKeyHeader, "key-1")
+			},
+			method: "POST", target: "/v1/products", body: `{"name":"Other","price":1,"stock":1}`, header: []string{idempotencyKeyHeader, "key-1"},
+			status: 409, code: "IDEMPOTENCY_KEY_REUSED",
+		},
+		{name: "not ready", prepare: func(s *Server, _ http.Handler) { s.ready.Store(false) }, method: "GET", target: "/v1/products/1", status: 503, code: "NOT_READY"},
+		{name: "maintenance", prepare: func(s *Server, _ http.Handler) { s.maintenance.Store(true) }, method: "POST", target: "/v1/products", body: product, status: 503, code: "MAINTENANCE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configure []func(*Config)
+			if tt.configure != nil {
+				configure = append(configure, tt.configure)
+			}
+			server, h := newTestServer(t, configure...)
+			if tt.prepare != nil {
+				tt.prepare(server, h)
+			}
+			rec := serve(h, tt.method, tt.target, tt.body, tt.header...)
+			assertError(t, rec, tt.status, ErrorCode(tt.code))
+		})
+	}
+}
+
+func TestRateLimitedErrorCode(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.RateLimit = 1 })
+	for range rateLimitBurst {
+		serve(h, "GET", "/v1/products/1", "")
+	}
+	assertError(t, serve(h, "GET", "/v1/products/1", ""), http.StatusTooManyRequests, "RATE_LIMITED")
+}
+
+func TestHandleAdjustStock(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		body      string
+		wantStock int32 // product 1's stock afterwards, starting from 10
+		wantCode  ErrorCode
+	}{
+		{"positive delta", "/v1/products/1/stock/adjust", `{"delta":5}`, 15, ""},
+		{"negative delta", "/v1/products/1/stock/adjust", `{"delta":-3}`, 7, ""},
+		{"down to zero", "/v1/products/1/stock/adjust", `{"delta":-10}`, 0, ""},
+		{"over-subtract", "/v1/products/1/stock/adjust", `{"delta":-11}`, 10, ErrCodeInsufficientStock},
+		{"int32 overflow", "/v1/products/1/stock/adjust", `{"delta":2147483647}`, 10, ErrCodeStockLimitExceeded},
+		{"zero delta", "/v1/products/1/stock/adjust", `{"delta":0}`, 10, ErrCodeInvalidQuantity},
+		{"missing product", "/v1/products/99/stock/adjust", `{"delta":1}`, 10, ErrCodeProductNotFound},
+	}
+	statuses := map[ErrorCode]int{
+		ErrCodeInsufficientStock:  http.StatusConflict,
+		ErrCodeStockLimitExceeded: http.StatusConflict,
+		ErrCodeInvalidQuantity:    http.StatusBadRequest,
+		ErrCodeProductNotFound:    http.StatusNotFound,
+	}
+	for backend, configure := range storeBackends(t) {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				_, h := newTestServer(t, configure)
+				rec := serve(h, "POST", tt.target, tt.body)
+				if tt.wantCode != "" {
+					assertError(t, rec, statuses[tt.wantCode], tt.wantCode)
+				} else {
+					assertStatus(t, rec, http.StatusOK)
+					if level := decodeBody[StockLevel](t, rec); level.ID != 1 || level.Stock != tt.wantStock {
+						t.Errorf("response = %+v, want stock %d", level, tt.wantStock)
+					}
+				}
+				assertStock(t, h, "1", tt.wantStock)
+			})
+		}
+	}
+}
+
+func TestHandleInventoryValue(t *testing.T) {
+	server, h := newTestServer(t)
+	// 0.10 * 3 sums to 0.30 exactly in cents, unlike in float64
+	createProduct(t, server.store, &Product{Name: "Pencil", Price: 10, Currency: "USD", Stock: 3, Category: "Stationery"})
+	createProduct(t, server.store, &Product{Name: "Croissant", Price: 250, Currency: "EUR", Stock: 4, Category: "Bakery"})
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"seeded total", "?category=Electronics", `{"totalValue":13899.1,"currency":"USD","category":"Electronics","products":3}`},
+		{"default currency", "", `{"totalValue":13899.4,"currency":"USD","products":4}`},
+		{"category without float drift", "?category=Stationery", `{"totalValue":0.3,"currency":"USD","category":"Stationery","products":1}`},
+		{"other currency", "?currency=EUR", `{"totalValue":10,"currency":"EUR","products":1}`},
+		{"no matches", "?category=Toys", `{"totalValue":0,"currency":"USD","category":"Toys","products":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, "GET", "/v1/products/value"+tt.query, "")
+			assertStatus(t, rec, http.StatusOK)
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+	assertError(t, serve(h, "GET", "/v1/products/value?currency=XYZ", ""), http.StatusBadRequest, ErrCodeInvalidParameter)
+}
+
+func TestHandleBatchUpdate(t *testing.T) {
+	const body = `[
+		{"id":1,"name":"Laptop Pro","price":1499,"stock":5},
+		{"id":99,"name":"Ghost","price":1,"stock":1},
+		{"id":2,"name":"","price":1,"stock":1},
+		{"id":3,"name":"Keyboard","price":1,"stock":1,"version":5},
+		null,
+		{"id":0,"name":"Zero","price":1,"stock":1},
+		{"id":3,"name":"Keyboard TKL","price":69.99,"stock":30,"version":1}
+	]`
+	want := []struct {
+		id     int32
+		status int
+		code   ErrorCode
+	}{
+		{1, http.StatusOK, ""},
+		{99, http.StatusNotFound, ErrCodeProductNotFound},
+		{2, http.StatusUnprocessableEntity, ErrCodeValidationFailed},
+		{3, http.StatusConflict, ErrCodeVersionConflict},
+		{0, http.StatusBadRequest, ErrCodeInvalidBody},
+		{0, http.StatusBadRequest, ErrCodeInvalidProductID},
+		{3, http.StatusOK, ""},
+	}
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			rec := serve(h, "POST", "/v1/products/batch-update", body)
+			assertStatus(t, rec, http.StatusOK)
+			result := decodeBody[BatchUpdateResult](t, rec)
+			if result.Succeeded != 2 || result.Failed != 5 || len(result.Results) != len(want) {
+				t.Fatalf("result = %+v", result)
+			}
+			for i, w := range want {
+				item := result.Results[i]
+				if item.ID != w.id || item.Status != w.status || item.ErrorCode != w.code {
+					t.Errorf("results[%d] = %+v, want id %d status %d code %q", i, item, w.id, w.status, w.code)
+				}
+			}
+			if product := result.Results[0].Product; product == nil || product.Version != 2 || product.Price != 149900 {
+				t.Errorf("results[0].product = %+v", product)
+			}
+
+			if product := getProduct(t, h, "1"); product.Name != "Laptop Pro" {
+				t.Errorf("product 1 = %+v, want it updated", product)
+			}
+			if product := getProduct(t, h, "2"); product.Name != "Mouse" || product.Version != 1 {
+				t.Errorf("product 2 = %+v, want it unchanged", product)
+			}
+			if product := getProduct(t, h, "3"); product.Name != "Keyboard TKL" || product.Version != 2 {
+				t.Errorf("product 3 = %+v, want only the current-version update applied", product)
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		target        string
+		wantNotReady  int // status while not ready
+		wantReadyBody string
+	}{
+		{"/v1/products/1", http.StatusServiceUnavailable, ""},
+		{"/v1/products", http.StatusServiceUnavailable, ""},
+		{"/ready", http.StatusServiceUnavailable, "READY"},
+		{"/health?simple=true", http.StatusOK, "OK"},
+	}
+	server, h := newTestServer(t)
+	for _, ready := range []bool{false, true} {
+		server.ready.Store(ready)
+		for _, tt := range tests {
+			rec := serve(h, "GET", tt.target, "")
+			if ready {
+				if rec.Code != http.StatusOK {
+					t.Errorf("ready: GET %s = %d, want 200", tt.target, rec.Code)
+				}
+				if tt.wantReadyBody != "" && rec.Body.String() != tt.wantReadyBody {
+					t.Errorf("ready: GET %s body = %q, want %q", tt.target, rec.Body.String(), tt.wantReadyBody)
+				}
+				continue
+			}
+			if rec.Code != tt.wantNotReady {
+				t.Errorf("not ready: GET %s = %d, want %d", tt.target, rec.Code, tt.wantNotReady)
+			}
+			if tt.wantNotReady == http.StatusServiceUnavailable {
+				if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(notReadyRetryAfter) {
+					t.Errorf("not ready: GET %s Retry-After = %q, want %d", tt.target, got, notReadyRetryAfter)
+				}
+				if body := decodeBody[Error](t, rec); body.ErrorCode != ErrCodeNotReady {
+					t.Errorf("not ready: GET %s errorCode = %s, want %s", tt.target, body.ErrorCode, ErrCodeNotReady)
+				}
+			}
+		}
+	}
+}
+
+func TestCategoryTags(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			rec := serve(h, "POST", "/v1/products", `{"name":"Desk","price":199,"stock":2,"category":"Furniture","categories":["Office","Home"]}`)
+			assertStatus(t, rec, http.StatusCreated)
+			desk := decodeBody[Product](t, rec)
+
+			for _, category := range []string{"Furniture", "Office", "Home", "office"} {
+				page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products?category="+category, ""))
+				if got := productIDs(page.Items); !slices.Equal(got, []int32{desk.ID}) {
+					t.Errorf("category %s lists %v, want [%d]", category, got, desk.ID)
+				}
+			}
+			if page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products?category=Garden", "")); len(page.Items) != 0 {
+				t.Errorf("category Garden lists %v, want none", productIDs(page.Items))
+			}
+
+			categories := decodeBody[[]CategoryCount](t, serve(h, "GET", "/v1/categories", ""))
+			want := []CategoryCount{{Category: "Electronics", Count: 3}, {Category: "Furniture", Count: 1}, {Category: "Home", Count: 1}, {Category: "Office", Count: 1}}
+			if !slices.Equal(categories, want) {
+				t.Errorf("categories = %+v, want %+v", categories, want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrorMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		body        string
+		wantMessage string
+	}{
+		{"price as string", "/v1/products", `{"name":"Widget","price":"abc","stock":1}`, "Invalid request body: field 'price' must be a number"},
+		{"price as bool", "/v1/products", `{"name":"Widget","price":true,"stock":1}`, "Invalid request body: field 'price' must be a number"},
+		{"price too large", "/v1/products", `{"name":"Widget","price":1e300,"stock":1}`, "Invalid request body: field 'price' is out of range"},
+		{"stock as string", "/v1/products", `{"name":"Widget","price":1,"stock":"ten"}`, "Invalid request body: field 'stock' must be an integer"},
+		{"fractional stock", "/v1/products", `{"name":"Widget","price":1,"stock":1.5}`, "Invalid request body: field 'stock' must be an integer"},
+		{"stock out of range", "/v1/products", `{"name":"Widget","price":1,"stock":3000000000}`, "Invalid request body: field 'stock' is out of range"},
+		{"name as number", "/v1/products", `{"name":42,"price":1,"stock":1}`, "Invalid request body: field 'name' must be a string"},
+		{"batch element field", "/v1/products/batch", `[{"name":"A","price":1,"stock":1},{"name":"B","price":1,"stock":"x"}]`, "Invalid request body: field '[1].stock' must be an integer"},
+		{"array for object", "/v1/products", `[]`, "Invalid request body: must be an object"},
+		{"syntax error", "/v1/products", `{"name":"Widget",,}`, "Invalid request body: malformed JSON at byte offset 18: invalid character ',' looking for beginning of object key string"},
+		{"truncated", "/v1/products", `{"name":"Widget"`, "Invalid request body: JSON ends unexpectedly"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := serve(h, "POST", tt.target, tt.body)
+			assertError(t, rec, http.StatusBadRequest, ErrCodeInvalidBody)
+			message := decodeBody[Error](t, rec).Message
+			if message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", message, tt.wantMessage)
+			}
+			// Messages speak JSON, not Go
+			for _, leak := range []string{"int32", "main.", "Go value", "Cents"} {
+				if strings.Contains(message, leak) {
+					t.Errorf("message %q leaks %q", message, leak)
+				}
+			}
+		})
+	}
+
+	// A price with fractions of a cent is well-formed but invalid
+	_, h := newTestServer(t)
+	assertFieldError(t, serve(h, "POST", "/v1/products", `{"name":"Widget","price":1.999,"stock":1}`), "price")
+}
+
+func TestHandleRestoreProduct(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			assertStatus(t, serve(h, "DELETE", "/v1/products/1", ""), http.StatusNoContent)
+			assertError(t, serve(h, "GET", "/v1/products/1", ""), http.StatusNotFound, ErrCodeProductNotFound)
+
+			// The response is the product as the restore left it
+			rec := serve(h, "POST", "/v1/products/1/restore", "")
+			assertStatus(t, rec, http.StatusOK)
+			restored := decodeBody[Product](t, rec)
+			if restored.ID != 1 || restored.Name != "Laptop" || restored.Deleted || restored.DeletedAt != nil || restored.Version != 3 {
+				t.Errorf("restored product = %+v, want live Laptop at version 3", restored)
+			}
+			if stored := getProduct(t, h, "1"); stored.Version != restored.Version {
+				t.Errorf("stored version = %d, want %d", stored.Version, restored.Version)
+			}
+
+			// Only soft-deleted products can be restored
+			assertError(t, serve(h, "POST", "/v1/products/1/restore", ""), http.StatusNotFound, ErrCodeProductNotFound)
+			assertError(t, serve(h, "POST", "/v1/products/99/restore", ""), http.StatusNotFound, ErrCodeProductNotFound)
+		})
+	}
+}
+
+func TestHandleDuplicateProduct(t *testing.T) {
+	server, h := newTestServer(t)
+	source := createProduct(t, server.store, &Product{
+		Name: "Desk", Description: "Oak desk", Price: 19999, Currency: "EUR", Stock: 2,
+		Category: "Furniture", Categories: []string{"Office"}, ImageURL: "https://example.com/desk.png",
+	})
+	sourceID := strconv.Itoa(int(source.ID))
+
+	rec := serve(h, "POST", "/v1/products/"+sourceID+"/duplicate", "")
+	assertStatus(t, rec, http.StatusCreated)
+	clone := decodeBody[Product](t, rec)
+	if clone.ID == source.ID {
+		t.Fatalf("clone has the source's ID %d", clone.ID)
+	}
+	if got, want := rec.Header().Get("Location"), "/v1/products/"+strconv.Itoa(int(clone.ID)); got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+	if clone.Name != "Desk (copy)" || clone.Description != source.Description || clone.Price != source.Price ||
+		clone.Currency != source.Currency || clone.Stock != source.Stock || clone.Category != source.Category ||
+		!slices.Equal(clone.Categories, source.Categories) || clone.ImageURL != source.ImageURL || clone.Version != 1 {
+		t.Errorf("clone = %+v, want the fields of %+v", clone, source)
+	}
+	if stored := getProduct(t, h, strconv.Itoa(int(clone.ID))); stored.Name != "Desk (copy)" {
+		t.Errorf("stored clone = %+v", stored)
+	}
+
+	// The clone is independent of its source
+	assertStatus(t, serve(h, "PUT", "/v1/products/"+sourceID, `{"name":"Desk","price":1,"stock":1,"categories":["Garden"]}`), http.StatusOK)
+	if stored := getProduct(t, h, strconv.Itoa(int(clone.ID))); !slices.Equal(stored.Categories, []string{"Office"}) || stored.Price != 19999 {
+		t.Errorf("clone changed with its source: %+v", stored)
+	}
+
+	rec = serve(h, "POST", "/v1/products/"+sourceID+"/duplicate?keepName=true", "")
+	assertStatus(t, rec, http.StatusCreated)
+	if kept := decodeBody[Product](t, rec); kept.Name != "Desk" {
+		t.Errorf("name with keepName = %q, want Desk", kept.Name)
+	}
+
+	assertError(t, serve(h, "POST", "/v1/products/99/duplicate", ""), http.StatusNotFound, ErrCodeProductNotFound)
+	assertStatus(t, serve(h, "DELETE", "/v1/products/1", ""), http.StatusNoContent)
+	assertError(t, serve(h, "POST", "/v1/products/1/duplicate", ""), http.StatusNotFound, ErrCodeProductNotFound)
+
+	// A name already at the length limit has no room for the suffix
+	long := createProduct(t, server.store, &Product{Name: strings.Repeat("n", 200), Price: 100, Currency: "USD"})
+	assertFieldError(t, serve(h, "POST", "/v1/products/"+strconv.Itoa(int(long.ID))+"/duplicate", ""), "name")
+}
+
+func TestCORS(t *testing.T) {
+	allowlist := func(cfg *Config) { cfg.AllowedOrigins = []string{"https://shop.example.com", "http://localhost:3000"} }
+	wildcard := func(cfg *Config) { cfg.AllowedOrigins = []string{"*"} }
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		method    string
+		origin    string
+		wantAllow string // expected Access-Control-Allow-Origin; "" for none
+		wantVary  bool
+	}{
+		{"allowed origin", allowlist, "GET", "https://shop.example.com", "https://shop.example.com", true},
+		{"second allowed origin", allowlist, "GET", "http://localhost:3000", "http://localhost:3000", true},
+		{"origin case differs", allowlist, "GET", "HTTPS://SHOP.EXAMPLE.COM", "HTTPS://SHOP.EXAMPLE.COM", true},
+		{"disallowed origin", allowlist, "GET", "https://evil.example.com", "", true},
+		{"no origin", allowlist, "GET", "", "", true},
+		{"allowed preflight", allowlist, "OPTIONS", "https://shop.example.com", "https://shop.example.com", true},
+		{"disallowed preflight", allowlist, "OPTIONS", "https://evil.example.com", "", true},
+		{"wildcard", wildcard, "GET", "https://anywhere.example.com", "*", false},
+		{"wildcard preflight", wildcard, "OPTIONS", "https://anywhere.example.com", "*", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, tt.configure)
+			rec := serve(h, tt.method, "/v1/products/1", "", "Origin", tt.origin, "Access-Control-Request-Method", "PUT")
+			if tt.method == "OPTIONS" {
+				assertStatus(t, rec, http.StatusNoContent)
+				if methods := rec.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(methods, "PUT") {
+					t.Errorf("Access-Control-Allow-Methods = %q, want PUT included", methods)
+				}
+				if headers := rec.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(headers, "Content-Type") || !strings.Contains(headers, "X-API-Key") {
+					t.Errorf("Access-Control-Allow-Headers = %q", headers)
+				}
+			} else {
+				assertStatus(t, rec, http.StatusOK)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllow)
+			}
+			if vary := slices.Contains(rec.Header().Values("Vary"), "Origin"); vary != tt.wantVary {
+				t.Errorf("Vary: Origin = %v, want %v", vary, tt.wantVary)
+			}
+		})
+	}
+}
+
+func TestParseAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"unset", nil, []string{"*"}},
+		{"list", map[string]string{"ALLOWED_ORIGINS": "https://a.example.com, http://localhost:3000/ ,"}, []string{"https://a.example.com", "http://localhost:3000"}},
+		{"single origin fallback", map[string]string{"ALLOWED_ORIGIN": "https://a.example.com"}, []string{"https://a.example.com"}},
+		{"list wins", map[string]string{"ALLOWED_ORIGINS": "https://b.example.com", "ALLOWED_ORIGIN": "https://a.example.com"}, []string{"https://b.example.com"}},
+		{"wildcard", map[string]string{"ALLOWED_ORIGINS": "*"}, []string{"*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseAllowedOrigins(func(name string) string { return tt.env[name] })
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("origins = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextIDStart(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure, func(cfg *Config) { cfg.NextIDStart = 1000 })
+			page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products", ""))
+			if got := productIDs(page.Items); !slices.Equal(got, []int32{1000, 1001, 1002}) {
+				t.Errorf("seeded IDs = %v, want [1000 1001 1002]", got)
+			}
+			rec := serve(h, "POST", "/v1/products", `{"name":"Desk","price":1,"stock":1}`)
+			assertStatus(t, rec, http.StatusCreated)
+			if created := decodeBody[Product](t, rec); created.ID != 1003 {
+				t.Errorf("created ID = %d, want 1003", created.ID)
+			}
+
+			// A reset starts again from the configured ID
+			assertStatus(t, serve(h, "DELETE", "/v1/products", ""), http.StatusNoContent)
+			rec = serve(h, "POST", "/v1/products", `{"name":"Desk","price":1,"stock":1}`)
+			assertStatus(t, rec, http.StatusCreated)
+			if created := decodeBody[Product](t, rec); created.ID != 1000 {
+				t.Errorf("created ID after reset = %d, want 1000", created.ID)
+			}
+		})
+	}
+
+	// Lowering the start never reuses IDs already handed out
+	store := NewProductStore()
+	for range 5 {
+		createProduct(t, store, &Product{Name: "Widget", Price: 1, Currency: "USD"})
+	}
+	store.SetFirstID(3)
+	if created := createProduct(t, store, &Product{Name: "Widget", Price: 1, Currency: "USD"}); created.ID != 6 {
+		t.Errorf("created ID after lowering the start = %d, want 6", created.ID)
+	}
+
+	for _, value := range []string{"0", "-5", "abc", "3000000000"} {
+		env := map[string]string{"NEXT_ID_START": value}
+		if _, err := LoadConfig(func(name string) string { return env[name] }); err == nil || !strings.Contains(err.Error(), "NEXT_ID_START") {
+			t.Errorf("NEXT_ID_START=%s: error = %v, want it rejected", value, err)
+		}
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	// params returns a query of n parameters
+	params := func(n int) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = "category=a"
+		}
+		return strings.Join(parts, "&")
+	}
+	tests := []struct {
+		name      string
+		maxBytes  int
+		maxParams int
+		query     string
+		wantOK    bool
+	}{
+		{"bytes at limit", 20, 0, "q=" + strings.Repeat("x", 18), true},
+		{"bytes over limit", 20, 0, "q=" + strings.Repeat("x", 19), false},
+		{"params at limit", 0, 5, params(5), true},
+		{"params over limit", 0, 5, params(6), false},
+		{"empty query", 20, 5, "", true},
+		{"limits disabled", 0, 0, params(500), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) {
+				cfg.MaxQueryBytes = tt.maxBytes
+				cfg.MaxQueryParams = tt.maxParams
+			})
+			rec := serve(h, "GET", "/v1/products?"+tt.query, "")
+			if tt.wantOK {
+				assertStatus(t, rec, http.StatusOK)
+				return
+			}
+			assertError(t, rec, http.StatusBadRequest, ErrCodeQueryTooLarge)
+		})
+	}
+
+	// The defaults allow ordinary filtered listings
+	_, h := newTestServer(t)
+	assertStatus(t, serve(h, "GET", "/v1/products?category=Electronics&minPrice=1&maxPrice=2000&sort=price_asc&limit=10&offset=0", ""), http.StatusOK)
+	assertError(t, serve(h, "GET", "/v1/products?"+params(defaultMaxQueryParams+1), ""), http.StatusBadRequest, ErrCodeQueryTooLarge)
+	assertError(t, serve(h, "GET", "/v1/products?q="+strings.Repeat("x", defaultMaxQueryBytes), ""), http.StatusBadRequest, ErrCodeQueryTooLarge)
+}
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name          string
+		timeout       time.Duration
+		releaseEarly  bool // finish the slow request before the timeout
+		wantAbandoned bool
+	}{
+		{"request completes", 5 * time.Second, true, false},
+		{"timeout elapses", 50 * time.Millisecond, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			server, _ := newTestServer(t)
+			started := make(chan struct{})
+			release := make(chan struct{})
+			ts := httptest.NewServer(server.InFlightMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-release
+				w.Write([]byte("done"))
+			})))
+			defer ts.Close()
+
+			responses := make(chan int, 1)
+			go func() {
+				resp, err := ts.Client().Get(ts.URL)
+				if err != nil {
+					responses <- 0
+					return
+				}
+				resp.Body.Close()
+				responses <- resp.StatusCode
+			}()
+			<-started
+			if n := server.inFlight.Load(); n != 1 {
+				t.Fatalf("in flight = %d, want 1", n)
+			}
+
+			drained := make(chan struct{})
+			go func() {
+				server.drain(ts.Config, tt.timeout)
+				close(drained)
+			}()
+			if tt.releaseEarly {
+				time.Sleep(20 * time.Millisecond)
+				close(release)
+			}
+			select {
+			case <-drained:
+			case <-time.After(5 * time.Second):
+				t.Fatal("drain did not return")
+			}
+			if !tt.releaseEarly {
+				close(release)
+			}
+			if status := <-responses; status != http.StatusOK {
+				t.Errorf("slow request status = %d, want 200", status)
+			}
+
+			logged := logs.String()
+			if !strings.Contains(logged, "Draining requests") || !strings.Contains(logged, "in_flight=1") {
+				t.Errorf("drain progress not logged:\n%s", logged)
+			}
+			if abandoned := strings.Contains(logged, "abandoning requests"); abandoned != tt.wantAbandoned {
+				t.Errorf("abandoned requests logged = %v, want %v:\n%s", abandoned, tt.wantAbandoned, logged)
+			}
+		})
+	}
+}
+
+func TestPriceFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []int32
+		wantErr bool
+	}{
+		{"min inclusive", "minPrice=79.99", []int32{1, 3}, false},
+		{"max inclusive", "maxPrice=29.99", []int32{2, 4}, false},
+		{"range", "minPrice=29.99&maxPrice=79.99", []int32{2, 3}, false},
+		{"fraction of a cent", "minPrice=29.991", nil, true},
+		{"cents boundary", "minPrice=0.3&maxPrice=0.3", []int32{4}, false},
+		{"whole units", "minPrice=1000", []int32{}, false},
+		{"exponent", "maxPrice=3e1", []int32{2, 4}, false},
+		{"negative", "minPrice=-1", nil, true},
+		{"not a number", "maxPrice=cheap", nil, true},
+		{"infinity", "maxPrice=Inf", nil, true},
+		{"inverted range", "minPrice=10&maxPrice=5", nil, true},
+	}
+	for backend, configure := range storeBackends(t) {
+		server, h := newTestServer(t, configure)
+		// 0.1 + 0.2 is not 0.3 in floating point; in cents it is exact
+		createProduct(t, server.store, &Product{Name: "Sticker", Price: 30, Currency: "USD", Stock: 1})
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				rec := serve(h, "GET", "/v1/products?"+tt.query, "")
+				if tt.wantErr {
+					assertError(t, rec, http.StatusBadRequest, ErrCodeInvalidParameter)
+					return
+				}
+				assertStatus(t, rec, http.StatusOK)
+				page := decodeBody[ProductPage](t, rec)
+				if got := productIDs(page.Items); !slices.Equal(got, tt.wantIDs) {
+					t.Errorf("IDs = %v, want %v", got, tt.wantIDs)
+				}
+			})
+		}
+	}
+}
+
+func TestMaxProducts(t *testing.T) {
+	const widget = `{"name":"Widget","price":1,"stock":1}`
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure, func(cfg *Config) { cfg.MaxProducts = 5 })
+
+			// Fill the store to the cap, then every way of creating is refused
+			assertError(t, serve(h, "POST", "/v1/products/batch", "["+widget+","+widget+","+widget+"]"), http.StatusInsufficientStorage, ErrCodeProductLimitReached)
+			assertStatus(t, serve(h, "POST", "/v1/products/batch", "["+widget+","+widget+"]"), http.StatusCreated)
+			rec := serve(h, "POST", "/v1/products", widget)
+			assertError(t, rec, http.StatusInsufficientStorage, ErrCodeProductLimitReached)
+			if msg := decodeBody[Error](t, rec).Message; !strings.Contains(msg, "at most 5 products") {
+				t.Errorf("message %q does not state the cap", msg)
+			}
+			assertError(t, serve(h, "POST", "/v1/products/1/duplicate", ""), http.StatusInsufficientStorage, ErrCodeProductLimitReached)
+			rec = serve(h, "POST", "/v1/products/import", strings.Join(csvHeader, ",")+"\n,Widget,,1.00,USD,1,,,\n", "Content-Type", "text/csv")
+			assertStatus(t, rec, http.StatusOK)
+			if summary := decodeBody[ImportSummary](t, rec); summary.Created != 0 || len(summary.Errors) != 1 {
+				t.Errorf("CSV import summary = %+v, want the row refused", summary)
+			}
+
+			// A soft-deleted product can be restored, so it keeps its place
+			assertStatus(t, serve(h, "DELETE", "/v1/products/1", ""), http.StatusNoContent)
+			assertError(t, serve(h, "POST", "/v1/products", widget), http.StatusInsufficientStorage, ErrCodeProductLimitReached)
+			assertStatus(t, serve(h, "POST", "/v1/products/1/restore", ""), http.StatusOK)
+
+			assertStatus(t, serve(h, "DELETE", "/v1/products", ""), http.StatusNoContent)
+			assertStatus(t, serve(h, "POST", "/v1/products", widget), http.StatusCreated)
+		})
+	}
+}
+
+func TestImportMaxProducts(t *testing.T) {
+	// snapshot returns a backup of products with the given IDs
+	snapshot := func(ids ...int) string {
+		products := make([]string, len(ids))
+		for i, id := range ids {
+			products[i] = fmt.Sprintf(`{"id":%d,"name":"Widget","price":1,"stock":1}`, id)
+		}
+		return `{"nextId":1,"products":[` + strings.Join(products, ",") + `]}`
+	}
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		deleted bool // soft-delete product 3 first
+		wantOK  bool
+	}{
+		{"merge within cap", "/v1/import?mode=merge", snapshot(4, 5), false, true},
+		{"merge over cap", "/v1/import?mode=merge", snapshot(4, 5, 6), false, false},
+		{"merge overwriting", "/v1/import?mode=merge", snapshot(1, 2, 3, 4, 5), false, true},
+		{"merge with deleted product", "/v1/import?mode=merge", snapshot(4, 5, 6), true, false},
+		{"replace at cap", "/v1/import?mode=replace", snapshot(1, 2, 3, 4, 5), false, true},
+		{"replace over cap", "/v1/import?mode=replace", snapshot(1, 2, 3, 4, 5, 6), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) { cfg.MaxProducts = 5 })
+			if tt.deleted {
+				assertStatus(t, serve(h, "DELETE", "/v1/products/3", ""), http.StatusNoContent)
+			}
+			rec := serve(h, "POST", tt.target, tt.body)
+			if tt.wantOK {
+				assertStatus(t, rec, http.StatusOK)
+				return
+			}
+			assertError(t, rec, http.StatusInsufficientStorage, ErrCodeProductLimitReached)
+			if stats := decodeBody[StoreStats](t, serve(h, "GET", "/stats", "")); stats.Products+stats.Deleted != 3 {
+				t.Errorf("stats after a refused import = %+v, want the store unchanged", stats)
+			}
+		})
+	}
+}
+
+func TestInStockFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []int32
+		wantErr bool
+	}{
+		{"no filter", "", []int32{1, 2, 3, 4, 5}, false},
+		{"in stock", "inStock=true", []int32{1, 2, 3}, false},
+		{"sold out", "inStock=false", []int32{4, 5}, false},
+		{"with category", "inStock=false&category=Garden", []int32{5}, false},
+		{"with price", "inStock=true&maxPrice=50", []int32{2}, false},
+		{"paginated", "inStock=true&limit=1&offset=1", []int32{2}, false},
+		{"invalid", "inStock=maybe", nil, true},
+	}
+	for backend, configure := range storeBackends(t) {
+		server, h := newTestServer(t, configure)
+		createProduct(t, server.store, &Product{Name: "Monitor", Price: 19999, Currency: "USD", Stock: 0, Category: "Electronics"})
+		createProduct(t, server.store, &Product{Name: "Rake", Price: 1999, Currency: "USD", Stock: 0, Category: "Garden"})
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				rec := serve(h, "GET", "/v1/products?"+tt.query, "")
+				if tt.wantErr {
+					assertError(t, rec, http.StatusBadRequest, ErrCodeInvalidParameter)
+					return
+				}
+				assertStatus(t, rec, http.StatusOK)
+				page := decodeBody[ProductPage](t, rec)
+				if got := productIDs(page.Items); !slices.Equal(got, tt.wantIDs) {
+					t.Errorf("IDs = %v, want %v", got, tt.wantIDs)
+				}
+			})
+		}
+	}
+
+	// The total counts every matching product, not just the page
+	_, h := newTestServer(t)
+	assertStatus(t, serve(h, "POST", "/v1/products/1/stock/adjust", `{"delta":-10}`), http.StatusOK)
+	if page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products?inStock=true&limit=1", "")); page.Total != 2 || len(page.Items) != 1 {
+		t.Errorf("page = %d items of %d, want 1 of 2", len(page.Items), page.Total)
+	}
+}
+
+func TestProductTimestamps(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			before := time.Now().UTC()
+			rec := serve(h, "POST", "/v1/products", `{"name":"Desk","price":199,"stock":2}`)
+			assertStatus(t, rec, http.StatusCreated)
+			created := decodeBody[Product](t, rec)
+			if created.CreatedAt.Before(before.Add(-time.Second)) || created.CreatedAt.After(time.Now().Add(time.Second)) {
+				t.Errorf("createdAt = %s, want about %s", created.CreatedAt, before)
+			}
+			if !created.UpdatedAt.Equal(created.CreatedAt) {
+				t.Errorf("updatedAt = %s, want createdAt %s", created.UpdatedAt, created.CreatedAt)
+			}
+
+			// Timestamps are RFC 3339 strings in UTC
+			raw := decodeBody[map[string]any](t, rec)
+			for _, field := range []string{"createdAt", "updatedAt"} {
+				value, _ := raw[field].(string)
+				if _, err := time.Parse(time.RFC3339Nano, value); err != nil || !strings.HasSuffix(value, "Z") {
+					t.Errorf("%s = %v, want an RFC 3339 UTC time", field, raw[field])
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			assertStatus(t, serve(h, "PUT", "/v1/products/4", `{"name":"Oak Desk","price":199,"stock":2}`), http.StatusOK)
+			updated := getProduct(t, h, "4")
+			if !updated.CreatedAt.Equal(created.CreatedAt) {
+				t.Errorf("createdAt changed on update: %s, was %s", updated.CreatedAt, created.CreatedAt)
+			}
+			if !updated.UpdatedAt.After(created.UpdatedAt) {
+				t.Errorf("updatedAt = %s after an update, want later than %s", updated.UpdatedAt, created.UpdatedAt)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			assertStatus(t, serve(h, "POST", "/v1/products/4/stock/adjust", `{"delta":1}`), http.StatusOK)
+			if adjusted := getProduct(t, h, "4"); !adjusted.UpdatedAt.After(updated.UpdatedAt) {
+				t.Errorf("updatedAt = %s after a stock change, want later than %s", adjusted.UpdatedAt, updated.UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestSortCreatedDesc(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			// Creation times out of ID order, with products 2 and 4 created together
+			rec := serve(h, "POST", "/v1/import?mode=replace", `{"nextId":5,"products":[
+				{"id":1,"name":"A","price":1,"stock":1,"createdAt":"2025-03-01T00:00:00Z"},
+				{"id":2,"name":"B","price":1,"stock":1,"createdAt":"2025-01-01T00:00:00Z"},
+				{"id":3,"name":"C","price":1,"stock":1,"createdAt":"2025-06-01T00:00:00Z"},
+				{"id":4,"name":"D","price":1,"stock":1,"createdAt":"2025-01-01T00:00:00Z"}]}`)
+			assertStatus(t, rec, http.StatusOK)
+
+			page := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products?sort=created_desc", ""))
+			if got := productIDs(page.Items); !slices.Equal(got, []int32{3, 1, 4, 2}) {
+				t.Errorf("IDs = %v, want [3 1 4 2]", got)
+			}
+			// A new product is the most recent
+			assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"E","price":1,"stock":1}`), http.StatusCreated)
+			page = decodeBody[ProductPage](t, serve(h, "GET", "/v1/products?sort=created_desc&limit=1", ""))
+			if got := productIDs(page.Items); !slices.Equal(got, []int32{5}) {
+				t.Errorf("newest = %v, want [5]", got)
+			}
+		})
+	}
+}
+
+// flushCounter records how often a handler flushes its response
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
+
+func TestWriteProductPageJSON(t *testing.T) {
+	for _, n := range []int{0, 1, listFlushInterval, 3*listFlushInterval + 7} {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			page := ProductPage{Items: []*Product{}, Total: n + 5, Limit: n, Offset: 5}
+			for i := range n {
+				page.Items = append(page.Items, &Product{ID: int32(i + 6), Name: fmt.Sprintf("Product \"%d\" <%d>", i, i), Price: Cents(i), Stock: 1})
+			}
+			w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+			writeProductPageJSON(w, page)
+
+			if !json.Valid(w.Body.Bytes()) {
+				t.Fatalf("invalid JSON: %s", w.Body)
+			}
+			want, err := json.Marshal(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Body.String(); got != string(want)+"\n" {
+				t.Errorf("body = %s, want %s", got, want)
+			}
+			if w.flushes != n/listFlushInterval {
+				t.Errorf("flushes = %d, want one per %d items (%d)", w.flushes, listFlushInterval, n/listFlushInterval)
+			}
+		})
+	}
+}
+
+func TestListProductsStreamed(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure)
+			var batch []string
+			for i := range 2 * maxPageLimit {
+				batch = append(batch, fmt.Sprintf(`{"name":"Item %d","price":%d.25,"stock":%d,"categories":["Bulk"]}`, i, i, i))
+			}
+			assertStatus(t, serve(h, "POST", "/v1/products/batch", "["+strings.Join(batch, ",")+"]"), http.StatusCreated)
+
+			rec := serve(h, "GET", "/v1/products?limit=500&offset=50", "")
+			assertStatus(t, rec, http.StatusOK)
+			if !json.Valid(rec.Body.Bytes()) {
+				t.Fatalf("streamed list is invalid JSON: %s", rec.Body)
+			}
+			page := decodeBody[ProductPage](t, rec)
+			if page.Total != 2*maxPageLimit+3 || page.Limit != maxPageLimit || page.Offset != 50 || len(page.Items) != maxPageLimit {
+				t.Fatalf("page = total %d, limit %d, offset %d, %d items", page.Total, page.Limit, page.Offset, len(page.Items))
+			}
+			if got := productIDs(page.Items); got[0] != 51 || got[len(got)-1] != 150 {
+				t.Errorf("IDs run %d..%d, want 51..150", got[0], got[len(got)-1])
+			}
+
+			// The streamed page matches the buffered encoding used for pretty output
+			pretty := decodeBody[ProductPage](t, serve(h, "GET", "/v1/products?limit=500&offset=50&pretty=true", ""))
+			streamed, _ := json.Marshal(page)
+			buffered, _ := json.Marshal(pretty)
+			if !bytes.Equal(streamed, buffered) {
+				t.Errorf("streamed page differs from the pretty-printed one:\n%s\nwant:\n%s", streamed, buffered)
+			}
+		})
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	requests := []struct {
+		name, target, body string
+		lenientStatus      int // status when unknown fields are ignored
+	}{
+		{"create", "/v1/products", `{"name":"Desk","price":1,"stock":1,"colour":"red"}`, http.StatusCreated},
+		{"replace", "/v1/products/1", `{"name":"Laptop","price":1,"stock":1,"colour":"red"}`, http.StatusOK},
+		{"details", "/v1/products/1/details", `{"name":"Laptop","price":1,"stock":1,"colour":"red"}`, http.StatusNoContent},
+		{"batch", "/v1/products/batch", `[{"name":"Desk","price":1,"stock":1,"colour":"red"}]`, http.StatusCreated},
+		{"batch update", "/v1/products/batch-update", `[{"id":1,"name":"Laptop","price":1,"stock":1,"colour":"red"}]`, http.StatusOK},
+		{"purchase", "/v1/products/1/purchase", `{"quantity":1,"coupon":"SAVE10"}`, http.StatusOK},
+		{"adjust stock", "/v1/products/1/stock/adjust", `{"delta":1,"reason":"recount"}`, http.StatusOK},
+		{"reserve", "/v1/products/1/reserve", `{"quantity":1,"note":"hold"}`, http.StatusCreated},
+		{"import", "/v1/import", `{"nextId":10,"products":[],"exportedBy":"backup-job"}`, http.StatusOK},
+	}
+	for _, strict := range []bool{true, false} {
+		for _, req := range requests {
+			t.Run(fmt.Sprintf("strict=%v/%s", strict, req.name), func(t *testing.T) {
+				_, h := newTestServer(t, func(cfg *Config) { cfg.StrictJSON = strict })
+				method := "POST"
+				if req.name == "replace" {
+					method = "PUT"
+				}
+				rec := serve(h, method, req.target, req.body)
+				if !strict {
+					assertStatus(t, rec, req.lenientStatus)
+					return
+				}
+				assertError(t, rec, http.StatusBadRequest, ErrCodeInvalidBody)
+				if msg := decodeBody[Error](t, rec).Message; !strings.HasPrefix(msg, "Invalid request body: unknown field ") {
+					t.Errorf("message = %q, want it to name the unknown field", msg)
+				}
+				// A rejected body changes nothing
+				if product := getProduct(t, h, "1"); product.Version != 1 || product.Stock != 10 {
+					t.Errorf("product 1 = %+v after a rejected request", product)
+				}
+			})
+		}
+	}
+}
+
+func TestStrictJSONDefault(t *testing.T) {
+	_, h := newTestServer(t)
+	assertError(t, serve(h, "POST", "/v1/products", `{"name":"Desk","price":1,"stock":1,"colour":"red"}`), http.StatusBadRequest, ErrCodeInvalidBody)
+
+	// Lenient parsing still rejects what is not well-formed or mistyped
+	_, h = newTestServer(t, func(cfg *Config) { cfg.StrictJSON = false })
+	assertError(t, serve(h, "POST", "/v1/products", `{"name":"Desk","price":"1","stock":1,"colour":"red"}`), http.StatusBadRequest, ErrCodeInvalidBody)
+	assertError(t, serve(h, "POST", "/v1/products", `{"name":"Desk","price":1,"stock":1,"colour":}`), http.StatusBadRequest, ErrCodeInvalidBody)
+	rec := serve(h, "POST", "/v1/products", `{"name":"Desk","price":1,"stock":1,"colour":"red"}`)
+	assertStatus(t, rec, http.StatusCreated)
+	if strings.Contains(rec.Body.String(), "colour") {
+		t.Errorf("unknown field echoed back: %s", rec.Body)
+	}
+}
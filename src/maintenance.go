@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// maintenanceRetryAfter is the Retry-After, in seconds, sent with writes
+// rejected during maintenance
+const maintenanceRetryAfter = 60
+
+// MaintenanceMode is the body of POST /admin/maintenance and the response of
+// both maintenance endpoints
+type MaintenanceMode struct {
+	Enabled *bool `json:"enabled" xml:"enabled"`
+}
+
+// MaintenanceMiddleware rejects mutating requests with a 503 while
+// maintenance mode is on; reads are served as usual
+func (s *Server) MaintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if s.maintenance.Load() {
+				w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+				writeErrorResponse(w, r, http.StatusServiceUnavailable, ErrCodeMaintenance, "Writes are disabled during maintenance")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleGetMaintenance handles GET /admin/maintenance
+func (s *Server) HandleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	enabled := s.maintenance.Load()
+	writeResponse(w, r, http.StatusOK, MaintenanceMode{Enabled: &enabled})
+}
+
+// HandleSetMaintenance handles POST /admin/maintenance, turning maintenance
+// mode on or off. Like every write it requires the API key whenever
+// authentication is enabled.
+func (s *Server) HandleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceMode
+	if !s.decodeJSONBody(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, ErrCodeInvalidBody, "Invalid request body: enabled is required")
+		return
+	}
+
+	if s.maintenance.Swap(*req.Enabled) != *req.Enabled {
+		slog.Warn("Maintenance mode changed", "enabled", *req.Enabled, "request_id", RequestIDFromContext(r.Context()))
+	}
+	writeResponse(w, r, http.StatusOK, req)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.APIKey = "secret" })
+	assertStatus(t, serve(h, "POST", "/admin/maintenance", `{"enabled":true}`, "X-API-Key", "secret"), http.StatusOK)
+	if mode := decodeBody[MaintenanceMode](t, serve(h, "GET", "/admin/maintenance", "")); mode.Enabled == nil || !*mode.Enabled {
+		t.Fatalf("mode = %+v, want enabled", mode)
+	}
+
+	writes := []struct{ method, target, body string }{
+		{"POST", "/v1/products", `{"name":"Desk","price":1,"stock":1}`},
+		{"PUT", "/v1/products/1", `{"name":"Gaming Laptop","price":1,"stock":1}`},
+		{"POST", "/v1/products/1/stock/adjust", `{"delta":1}`},
+		{"DELETE", "/v1/products/1", ""},
+		{"POST", "/v1/products/1/purchase", `{"quantity":1}`},
+	}
+	for _, w := range writes {
+		rec := serve(h, w.method, w.target, w.body, "X-API-Key", "secret")
+		assertError(t, rec, http.StatusServiceUnavailable, ErrCodeMaintenance)
+		if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(maintenanceRetryAfter) {
+			t.Errorf("%s %s Retry-After = %q, want %d", w.method, w.target, got, maintenanceRetryAfter)
+		}
+	}
+	if product := getProduct(t, h, "1"); product.Name != "Laptop" || product.Stock != 10 {
+		t.Errorf("product 1 = %+v, changed during maintenance", product)
+	}
+	assertStatus(t, serve(h, "GET", "/v1/products", ""), http.StatusOK)
+	assertStatus(t, serve(h, "GET", "/health", ""), http.StatusOK)
+
+	// The toggle keeps working so maintenance can be ended
+	assertStatus(t, serve(h, "POST", "/admin/maintenance", `{"enabled":false}`, "X-API-Key", "secret"), http.StatusOK)
+	assertStatus(t, serve(h, "POST", "/v1/products/1/purchase", `{"quantity":1}`, "X-API-Key", "secret"), http.StatusOK)
+}
+
+func TestAdminEndpointsAuth(t *testing.T) {
+	// The maintenance toggle follows the same API key policy as the other
+	// store-wide writes: open when no key is configured, guarded otherwise
+	endpoints := []struct{ method, target, body string }{
+		{"POST", "/admin/maintenance", `{"enabled":true}`},
+		{"DELETE", "/v1/products", ""},
+		{"POST", "/v1/import", `{"nextId":1,"products":[]}`},
+	}
+	tests := []struct {
+		name   string
+		apiKey string
+		header []string
+		status int // 0 when the request must succeed
+	}{
+		{"no key configured", "", nil, 0},
+		{"missing key", "secret", nil, http.StatusUnauthorized},
+		{"wrong key", "secret", []string{"X-API-Key", "guess"}, http.StatusUnauthorized},
+		{"matching key", "secret", []string{"X-API-Key", "secret"}, 0},
+	}
+	for _, e := range endpoints {
+		for _, tt := range tests {
+			t.Run(e.method+" "+e.target+"/"+tt.name, func(t *testing.T) {
+				server, h := newTestServer(t, func(cfg *Config) { cfg.APIKey = tt.apiKey })
+				rec := serve(h, e.method, e.target, e.body, tt.header...)
+				if tt.status != 0 {
+					assertError(t, rec, tt.status, ErrCodeUnauthorized)
+				} else if rec.Code >= 300 {
+					t.Errorf("status = %d, want success; body: %s", rec.Code, rec.Body)
+				}
+				if enabled := server.maintenance.Load(); enabled != (tt.status == 0 && e.target == "/admin/maintenance") {
+					t.Errorf("maintenance enabled = %v after status %d", enabled, rec.Code)
+				}
+				// Reading the mode needs no key
+				assertStatus(t, serve(h, "GET", "/admin/maintenance", ""), http.StatusOK)
+			})
+		}
+	}
+}
+
+func TestSetMaintenanceInvalidBody(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.APIKey = "secret" })
+	assertError(t, serve(h, "POST", "/admin/maintenance", `{}`, "X-API-Key", "secret"), http.StatusBadRequest, ErrCodeInvalidBody)
+	assertError(t, serve(h, "POST", "/admin/maintenance", `{"enabled":"yes"}`, "X-API-Key", "secret"), http.StatusBadRequest, ErrCodeInvalidBody)
+}
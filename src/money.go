@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Cents is an amount of money in hundredths of a currency unit. Storing
+// prices as integers keeps sums exact; in JSON, XML and CSV an amount is still
+// written as a decimal number such as 9.99, so clients see no difference.
+type Cents int64
+
+// centsType is the reflect.Type of Cents, for recognizing its decode errors
+var centsType = reflect.TypeOf(Cents(0))
+
+// maxCentsUnits bounds the whole units an amount may have so its cents fit in
+// an int64
+const maxCentsUnits = 9e16
+
+// Errors returned by ParseCents
+var (
+	ErrCentsSyntax    = errors.New("not a decimal number")
+	ErrCentsPrecision = errors.New("more than two decimal places")
+	ErrCentsRange     = errors.New("out of range")
+)
+
+// decimalPattern matches a plain decimal number with an optional exponent
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// ParseCents parses a decimal amount such as "9.99" exactly. Amounts with
+// more than two decimal places are rejected rather than rounded.
+func ParseCents(s string) (Cents, error) {
+	if !decimalPattern.MatchString(s) {
+		return 0, ErrCentsSyntax
+	}
+	// Range-check first so a huge exponent is never expanded exactly
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.Abs(f) >= maxCentsUnits {
+		return 0, ErrCentsRange
+	}
+	amount, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrCentsSyntax
+	}
+	amount.Mul(amount, big.NewRat(100, 1))
+	if !amount.IsInt() {
+		return 0, ErrCentsPrecision
+	}
+	return Cents(amount.Num().Int64()), nil
+}
+
+// Fixed formats the amount with exactly two decimal places, e.g. "9.90"
+func (c Cents) Fixed() string {
+	sign, n := "", int64(c)
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, n/100, n%100)
+}
+
+// String formats the amount without trailing zeros, e.g. "9.9" or "10", as a
+// float64 price used to be encoded
+func (c Cents) String() string {
+	return strings.TrimSuffix(strings.TrimRight(c.Fixed(), "0"), ".")
+}
+
+// MarshalJSON writes the amount as a JSON number
+func (c Cents) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalJSON reads the amount from a JSON number. Anything else, or a
+// number that is not a whole number of cents, is reported as a
+// *json.UnmarshalTypeError so the decoder attaches the field name.
+func (c *Cents) UnmarshalJSON(data []byte) error {
+	literal := string(data)
+	if literal == "null" {
+		return nil
+	}
+	if kind := jsonValueKind(literal); kind != "number" {
+		return &json.UnmarshalTypeError{Value: kind, Type: centsType}
+	}
+	amount, err := ParseCents(literal)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: "number " + literal, Type: centsType}
+	}
+	*c = amount
+	return nil
+}
+
+// MarshalText writes the amount for XML
+func (c Cents) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// jsonValueKind names the kind of the JSON value literal, as the decoder does
+// in type errors
+func jsonValueKind(literal string) string {
+	switch {
+	case strings.HasPrefix(literal, `"`):
+		return "string"
+	case literal == "true" || literal == "false":
+		return "bool"
+	case strings.HasPrefix(literal, "["):
+		return "array"
+	case strings.HasPrefix(literal, "{"):
+		return "object"
+	}
+	return "number"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Cents
+		wantErr error
+	}{
+		{"9.99", 999, nil},
+		{"9.9", 990, nil},
+		{"10", 1000, nil},
+		{"10.", 1000, nil},
+		{".5", 50, nil},
+		{"0", 0, nil},
+		{"1e2", 10000, nil},
+		{"1.5e-1", 15, nil},
+		{"-2.50", -250, nil},
+		{"9.990", 999, nil},
+		{"9.999", 0, ErrCentsPrecision},
+		{"0.001", 0, ErrCentsPrecision},
+		{"1e-3", 0, ErrCentsPrecision},
+		{"", 0, ErrCentsSyntax},
+		{"abc", 0, ErrCentsSyntax},
+		{"1,5", 0, ErrCentsSyntax},
+		{"0x10", 0, ErrCentsSyntax},
+		{"NaN", 0, ErrCentsSyntax},
+		{"1e300", 0, ErrCentsRange},
+	}
+	for _, tt := range tests {
+		got, err := ParseCents(tt.in)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("ParseCents(%q) = %d, %v; want %d, %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCentsFormatting(t *testing.T) {
+	tests := []struct {
+		in         Cents
+		wantString string
+		wantFixed  string
+	}{
+		{999, "9.99", "9.99"},
+		{990, "9.9", "9.90"},
+		{1000, "10", "10.00"},
+		{5, "0.05", "0.05"},
+		{-250, "-2.5", "-2.50"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.wantString {
+			t.Errorf("Cents(%d).String() = %q, want %q", tt.in, got, tt.wantString)
+		}
+		if got := tt.in.Fixed(); got != tt.wantFixed {
+			t.Errorf("Cents(%d).Fixed() = %q, want %q", tt.in, got, tt.wantFixed)
+		}
+	}
+}
+
+func TestPricePrecision(t *testing.T) {
+	tests := []struct {
+		name      string
+		price     string
+		wantPrice Cents
+	}{
+		{"two decimals", "9.99", 999},
+		{"whole number", "10", 1000},
+		{"trailing zero", "9.990", 999},
+		{"three decimals", "9.999", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := serve(h, "POST", "/v1/products", `{"name":"Widget","price":`+tt.price+`,"stock":1}`)
+			if tt.wantPrice == 0 {
+				// Rejected rather than rounded
+				assertFieldError(t, rec, "price")
+				return
+			}
+			assertStatus(t, rec, http.StatusCreated)
+			if product := decodeBody[Product](t, rec); product.Price != tt.wantPrice {
+				t.Errorf("price = %d cents, want %d", product.Price, tt.wantPrice)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// NameIndex maps normalized product names to the live products using them, so
+// PREVENT_DUPLICATE_NAMES can check a new name without scanning the store
+type NameIndex struct {
+	mu    sync.Mutex
+	ids   map[string]map[int32]struct{} // normalized name to the IDs using it
+	names map[int32]string              // ID to normalized name
+}
+
+// NewNameIndex creates an empty index
+func NewNameIndex() *NameIndex {
+	return &NameIndex{
+		ids:   make(map[string]map[int32]struct{}),
+		names: make(map[int32]string),
+	}
+}
+
+// nameKey normalizes a product name for comparison, ignoring case and
+// surrounding whitespace
+func nameKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Lookup returns the lowest ID of a live product named name, if any
+func (x *NameIndex) Lookup(name string) (int32, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var lowest int32
+	for id := range x.ids[nameKey(name)] {
+		if lowest == 0 || id < lowest {
+			lowest = id
+		}
+	}
+	return lowest, lowest != 0
+}
+
+// set records that product id is named name, replacing any earlier name
+func (x *NameIndex) set(id int32, name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+	key := nameKey(name)
+	if x.ids[key] == nil {
+		x.ids[key] = make(map[int32]struct{})
+	}
+	x.ids[key][id] = struct{}{}
+	x.names[id] = key
+}
+
+// remove drops product id from the index
+func (x *NameIndex) remove(id int32) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(id)
+}
+
+func (x *NameIndex) removeLocked(id int32) {
+	key, exists := x.names[id]
+	if !exists {
+		return
+	}
+	delete(x.names, id)
+	delete(x.ids[key], id)
+	if len(x.ids[key]) == 0 {
+		delete(x.ids, key)
+	}
+}
+
+// Rebuild replaces the index contents with products
+func (x *NameIndex) Rebuild(products []*Product) {
+	x.mu.Lock()
+	x.ids = make(map[string]map[int32]struct{})
+	x.names = make(map[int32]string)
+	x.mu.Unlock()
+	for _, product := range products {
+		x.set(product.ID, product.Name)
+	}
+}
+
+// enableDuplicateNameCheck turns on PREVENT_DUPLICATE_NAMES. The index follows
+// every store write; it must be filled with Rebuild once the store is loaded.
+func (s *Server) enableDuplicateNameCheck() {
+	s.names = NewNameIndex()
+	s.observeStore(func(event ProductEvent) {
+		switch {
+		case event.Type == EventReset || event.Type == EventImport:
+			s.names.Rebuild(s.store.ListProducts())
+		case event.Product == nil:
+			s.names.remove(event.ID)
+		default:
+			s.names.set(event.ID, event.Product.Name)
+		}
+	})
+}
+
+// duplicateName returns why name cannot be used for a new product, or "" if
+// it can. A name is taken when a live product already has it or when it
+// repeats an earlier product of the same request, tracked in seen. Names are
+// never taken when duplicates are allowed.
+func (s *Server) duplicateName(name string, seen map[string]bool) string {
+	if s.names == nil {
+		return ""
+	}
+	if id, exists := s.names.Lookup(name); exists {
+		return fmt.Sprintf("product name %q is already used by product %d", strings.TrimSpace(name), id)
+	}
+	key := nameKey(name)
+	if seen[key] {
+		return fmt.Sprintf("product name %q appears more than once", strings.TrimSpace(name))
+	}
+	seen[key] = true
+	return ""
+}
+
+// checkNames writes a 409 and returns false when any of products would
+// duplicate a name. Callers must hold lockCreates until the products are
+// stored.
+func (s *Server) checkNames(w http.ResponseWriter, r *http.Request, products ...*Product) bool {
+	seen := make(map[string]bool)
+	for i, product := range products {
+		msg := s.duplicateName(product.Name, seen)
+		if msg == "" {
+			continue
+		}
+		if len(products) > 1 {
+			msg = fmt.Sprintf("[%d] %s", i, msg)
+		}
+		writeErrorResponse(w, r, http.StatusConflict, ErrCodeDuplicateName, "Duplicate name: "+msg)
+		return false
+	}
+	return true
+}
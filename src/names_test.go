@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNameIndex(t *testing.T) {
+	index := NewNameIndex()
+	index.Rebuild([]*Product{{ID: 3, Name: "Laptop"}, {ID: 1, Name: "laptop"}, {ID: 2, Name: "Mouse"}})
+
+	if id, ok := index.Lookup("  LAPTOP "); !ok || id != 1 {
+		t.Errorf("Lookup(LAPTOP) = %d, %v; want the lowest ID 1", id, ok)
+	}
+	index.set(1, "Gaming Laptop")
+	if id, ok := index.Lookup("laptop"); !ok || id != 3 {
+		t.Errorf("Lookup(laptop) after rename = %d, %v; want 3", id, ok)
+	}
+	index.remove(3)
+	if id, ok := index.Lookup("laptop"); ok {
+		t.Errorf("Lookup(laptop) after removal = %d, want none", id)
+	}
+	if id, ok := index.Lookup("gaming laptop"); !ok || id != 1 {
+		t.Errorf("Lookup(gaming laptop) = %d, %v; want 1", id, ok)
+	}
+}
+
+func TestPreventDuplicateNames(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			_, h := newTestServer(t, configure, func(cfg *Config) { cfg.PreventDuplicateNames = true })
+
+			rec := serve(h, "POST", "/v1/products", `{"name":"  lAPTOP ","price":1,"stock":1}`)
+			assertError(t, rec, http.StatusConflict, ErrCodeDuplicateName)
+			if msg := decodeBody[Error](t, rec).Message; !strings.Contains(msg, "product 1") {
+				t.Errorf("message %q does not name the existing product", msg)
+			}
+			assertError(t, serve(h, "POST", "/v1/products/2/duplicate?keepName=true", ""), http.StatusConflict, ErrCodeDuplicateName)
+
+			// Names repeated within one batch conflict with each other
+			rec = serve(h, "POST", "/v1/products/batch", `[{"name":"Desk","price":1,"stock":1},{"name":"desk","price":1,"stock":1}]`)
+			assertError(t, rec, http.StatusConflict, ErrCodeDuplicateName)
+			if msg := decodeBody[Error](t, rec).Message; !strings.Contains(msg, "[1]") {
+				t.Errorf("message %q does not name the batch item", msg)
+			}
+
+			// Renaming or deleting a product frees its name
+			assertStatus(t, serve(h, "PUT", "/v1/products/2", `{"name":"Trackball","price":1,"stock":1}`), http.StatusOK)
+			assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Mouse","price":1,"stock":1}`), http.StatusCreated)
+			assertStatus(t, serve(h, "DELETE", "/v1/products/1", ""), http.StatusNoContent)
+			assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Laptop","price":1,"stock":1}`), http.StatusCreated)
+		})
+	}
+}
+
+func TestDuplicateNamesAllowed(t *testing.T) {
+	_, h := newTestServer(t)
+	assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Laptop","price":1,"stock":1}`), http.StatusCreated)
+	assertStatus(t, serve(h, "POST", "/v1/products/batch", `[{"name":"Desk","price":1,"stock":1},{"name":"desk","price":1,"stock":1}]`), http.StatusCreated)
+	assertStatus(t, serve(h, "POST", "/v1/products/1/duplicate?keepName=true", ""), http.StatusCreated)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	_ "embed"
+	"log/slog"
+	"net/http"
+)
+
+// openAPISpec is the hand-maintained OpenAPI document describing the API.
+// Keep it in sync with the routes registered in main.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// swaggerUIPage renders Swagger UI against /openapi.json
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Product Store API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// HandleOpenAPISpec handles GET /openapi.json
+func HandleOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(openAPISpec); err != nil {
+		slog.Error("Error writing OpenAPI spec", "error", err)
+	}
+}
+
+// HandleDocs handles GET /docs, serving a Swagger UI page
+func HandleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(swaggerUIPage)); err != nil {
+		slog.Error("Error writing docs page", "error", err)
+	}
+}
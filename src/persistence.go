@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// storeSnapshot is the on-disk representation of a ProductStore, also used as
+// the GET /export and POST /import document for either backend
+type storeSnapshot struct {
+	NextID   int32      `json:"nextId"`
+	Products []*Product `json:"products"`
+}
+
+// ErrInvalidSnapshot is returned when a snapshot's products cannot be loaded
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// prepare checks the snapshot's products and fills in fields missing from
+// older snapshots, stamping them with now. It returns the next ID to assign:
+// the snapshot's own, raised past every product ID it contains.
+func (snapshot *storeSnapshot) prepare(now time.Time) (int32, error) {
+	seen := make(map[int32]bool, len(snapshot.Products))
+	nextID := snapshot.NextID
+	for _, product := range snapshot.Products {
+		if product == nil || product.ID < 1 {
+			return 0, fmt.Errorf("%w: invalid product entry", ErrInvalidSnapshot)
+		}
+		if seen[product.ID] {
+			return 0, fmt.Errorf("%w: duplicate product id %d", ErrInvalidSnapshot, product.ID)
+		}
+		seen[product.ID] = true
+		// Snapshots written before versioning have no version
+		if product.Version < 1 {
+			product.Version = 1
+		}
+		// or modification time
+		if product.UpdatedAt.IsZero() {
+			product.UpdatedAt = now
+		}
+		// or creation time, for which the last write is the best guess
+		if product.CreatedAt.IsZero() {
+			product.CreatedAt = product.UpdatedAt
+		}
+		// Never hand out an ID that is already in use
+		if product.ID >= nextID {
+			nextID = product.ID + 1
+		}
+	}
+	if nextID < 1 {
+		nextID = 1
+	}
+	return nextID, nil
+}
+
+// Snapshot returns every product, including soft-deleted ones, sorted by ID,
+// along with the next ID, as one consistent view (thread-safe read)
+func (s *ProductStore) Snapshot() (storeSnapshot, error) {
+	s.idMu.Lock()
+	defer s.idMu.Unlock()
+	s.rlockAll()
+	defer s.runlockAll()
+
+	snapshot := storeSnapshot{NextID: s.nextID, Products: []*Product{}}
+	s.eachProduct(func(product *Product) {
+		snapshot.Products = append(snapshot.Products, product)
+	})
+	sort.Slice(snapshot.Products, func(i, j int) bool {
+		return snapshot.Products[i].ID < snapshot.Products[j].ID
+	})
+	return snapshot, nil
+}
+
+// Import loads snapshot into the store (thread-safe write). With replace the
+// store is emptied first; otherwise snapshot products overwrite those with the
+// same ID and the rest are kept. The next ID never moves backwards past an ID
+// in use. The store is left untouched if the snapshot is invalid.
+func (s *ProductStore) Import(snapshot storeSnapshot, replace bool) error {
+	nextID, err := snapshot.prepare(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	s.idMu.Lock()
+	defer s.idMu.Unlock()
+	s.lockAll()
+	defer s.unlockAll()
+	if replace {
+		for i := range s.shards {
+			s.shards[i].products = make(map[int32]*Product)
+		}
+		s.nextID = s.firstID
+	}
+	for _, product := range snapshot.Products {
+		s.shard(product.ID).products[product.ID] = product
+	}
+	s.nextID = max(s.nextID, nextID)
+	return nil
+}
+
+// SaveToFile writes all products and the next ID to path as JSON (thread-safe read).
+// The file is written to a temporary location first and renamed into place so a
+// crash mid-write never leaves a truncated file behind.
+func (s *ProductStore) SaveToFile(path string) error {
+	snapshot, _ := s.Snapshot()
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("renaming temp file: %w", err)
+	}
+	return nil
+}
+
+// LoadFromFile replaces the store contents with the snapshot at path (thread-safe write).
+// The store is left untouched if the file cannot be read or parsed.
+func (s *ProductStore) LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snapshot storeSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if err := s.Import(snapshot, true); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIPKey is the context key under which the resolved client IP is stored
+type clientIPKey struct{}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs or single IP
+// addresses, as given in TRUSTED_PROXIES
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a valid CIDR", entry)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid IP address or CIDR", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ClientIPMiddleware resolves the client's IP address for logging and rate
+// limiting. Behind a load balancer RemoteAddr is the proxy, so when the
+// connection comes from one of trustedProxies the address is taken from
+// X-Forwarded-For, skipping trusted hops from the right, or else X-Real-IP.
+// Connections from anywhere else could forge those headers, so for them the
+// headers are ignored and RemoteAddr is used.
+func ClientIPMiddleware(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolveClientIP(r, trustedProxies)
+			ctx := context.WithValue(r.Context(), clientIPKey{}, ip)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// resolveClientIP returns the address of the first hop, walking back from the
+// connection, that is not a trusted proxy
+func resolveClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	remote := remoteIP(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !isTrustedProxy(addr, trustedProxies) {
+		return remote
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return realIP.Unmap().String()
+		}
+		return remote
+	}
+	// Each proxy appends the address it received the request from, so only
+	// the entries added by trusted proxies, read from the right, can be believed
+	client := addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap()
+		if !isTrustedProxy(client, trustedProxies) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// isTrustedProxy reports whether addr falls within any of trustedProxies
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP address of the connecting peer
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the client's IP address resolved by ClientIPMiddleware,
+// falling back to the connecting peer's
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
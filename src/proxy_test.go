@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := parseTrustedProxies(" 10.1.2.3/8, 192.0.2.1 ,, ::ffff:198.51.100.7, 2001:db8::/32")
+	if err != nil {
+		t.Fatalf("valid list: %v", err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("198.51.100.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if !slices.Equal(prefixes, want) {
+		t.Errorf("prefixes = %v, want %v", prefixes, want)
+	}
+	for _, value := range []string{"10.0.0.0/33", "proxy.internal", "10.0.0.1,nope"} {
+		if _, err := parseTrustedProxies(value); err == nil {
+			t.Errorf("parseTrustedProxies(%q) accepted", value)
+		}
+	}
+}
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+	tests := []struct {
+		name    string
+		remote  string
+		trusted []netip.Prefix
+		header  []string
+		want    string
+	}{
+		{"no proxies configured", "192.0.2.1:1234", nil, []string{"X-Forwarded-For", "203.0.113.9"}, "192.0.2.1"},
+		{"untrusted peer forwarding", "198.51.100.7:1234", trusted, []string{"X-Forwarded-For", "203.0.113.9"}, "198.51.100.7"},
+		{"untrusted peer real IP", "198.51.100.7:1234", trusted, []string{"X-Real-IP", "203.0.113.9"}, "198.51.100.7"},
+		{"trusted peer forwarding", "192.0.2.1:1234", trusted, []string{"X-Forwarded-For", "203.0.113.9"}, "203.0.113.9"},
+		{"trusted hops skipped", "10.0.0.2:1234", trusted, []string{"X-Forwarded-For", "6.6.6.6, 203.0.113.9, 10.0.0.5"}, "203.0.113.9"},
+		{"all hops trusted", "10.0.0.2:1234", trusted, []string{"X-Forwarded-For", "10.0.0.7, 10.0.0.5"}, "10.0.0.7"},
+		{"unparseable hop", "10.0.0.2:1234", trusted, []string{"X-Forwarded-For", "203.0.113.9, garbage"}, "10.0.0.2"},
+		{"trusted peer real IP", "10.0.0.2:1234", trusted, []string{"X-Real-IP", " 203.0.113.9 "}, "203.0.113.9"},
+		{"invalid real IP", "10.0.0.2:1234", trusted, []string{"X-Real-IP", "somewhere"}, "10.0.0.2"},
+		{"trusted peer without headers", "10.0.0.2:1234", trusted, nil, "10.0.0.2"},
+		{"mapped IPv4 peer", "[::ffff:10.0.0.2]:1234", trusted, []string{"X-Forwarded-For", "203.0.113.9"}, "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/products", nil)
+			req.RemoteAddr = tt.remote
+			for i := 0; i+1 < len(tt.header); i += 2 {
+				req.Header.Set(tt.header[i], tt.header[i+1])
+			}
+			if got := resolveClientIP(req, tt.trusted); got != tt.want {
+				t.Errorf("client IP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	// httptest requests come from 192.0.2.1
+	_, h := newTestServer(t, func(cfg *Config) {
+		cfg.RateLimit = 1
+		cfg.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}
+	})
+	for range rateLimitBurst {
+		assertStatus(t, serve(h, "GET", "/v1/products/1", "", "X-Forwarded-For", "203.0.113.9"), http.StatusOK)
+	}
+	assertError(t, serve(h, "GET", "/v1/products/1", "", "X-Forwarded-For", "203.0.113.9"), http.StatusTooManyRequests, ErrCodeRateLimited)
+
+	// Clients behind the same proxy have their own buckets
+	assertStatus(t, serve(h, "GET", "/v1/products/1", "", "X-Forwarded-For", "203.0.113.10"), http.StatusOK)
+	assertStatus(t, serve(h, "GET", "/v1/products/1", "", "X-Real-IP", "203.0.113.11"), http.StatusOK)
+}
+
+func TestRateLimitIgnoresForgedHeaders(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.RateLimit = 1 })
+	for i := range rateLimitBurst {
+		forged := netip.AddrFrom4([4]byte{203, 0, 113, byte(i)}).String()
+		assertStatus(t, serve(h, "GET", "/v1/products/1", "", "X-Forwarded-For", forged), http.StatusOK)
+	}
+	// Without a trusted proxy every request is the peer's, whatever it claims
+	assertError(t, serve(h, "GET", "/v1/products/1", "", "X-Forwarded-For", "203.0.113.200"), http.StatusTooManyRequests, ErrCodeRateLimited)
+}
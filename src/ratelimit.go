@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// rateLimitBurst is the number of requests a client may make back-to-back
+	rateLimitBurst = 5
+	// rateLimitIdleTTL is how long an unused bucket is kept before eviction
+	rateLimitIdleTTL = 3 * time.Minute
+)
+
+// clientBucket is a token bucket plus the last time it was used
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter enforces a per-client-IP token bucket
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*clientBucket
+	limit   rate.Limit
+	burst   int
+}
+
+// NewRateLimiter creates a limiter allowing rps requests per second per client
+func NewRateLimiter(rps float64) *RateLimiter {
+	return &RateLimiter{
+		buckets: make(map[string]*clientBucket),
+		limit:   rate.Limit(rps),
+		burst:   rateLimitBurst,
+	}
+}
+
+// bucket returns the limiter for ip, creating it on first use
+func (rl *RateLimiter) bucket(ip string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b, exists := rl.buckets[ip]
+	if !exists {
+		b = &clientBucket{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.buckets[ip] = b
+	}
+	b.lastSeen = time.Now()
+	return b.limiter
+}
+
+// evictIdle removes buckets that have not been used within rateLimitIdleTTL
+func (rl *RateLimiter) evictIdle() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cutoff := time.Now().Add(-rateLimitIdleTTL)
+	evicted := 0
+	for ip, b := range rl.buckets {
+		if b.lastSeen.Before(cutoff) {
+			delete(rl.buckets, ip)
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		slog.Debug("Evicted idle rate limit buckets", "count", evicted, "remaining", len(rl.buckets))
+	}
+}
+
+// RunEviction periodically evicts idle buckets until stop is closed
+func (rl *RateLimiter) RunEviction(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rl.evictIdle()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Middleware rejects requests beyond the client's rate with 429 Too Many Requests
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reservation := rl.bucket(clientIP(r)).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			// Don't consume a token for a request we are rejecting
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeErrorResponse(w, r, http.StatusTooManyRequests, ErrCodeRateLimited, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultReservationTTL is how long reserved stock is held before it is
+	// returned to the product
+	defaultReservationTTL = 15 * time.Minute
+	// reservationSweepInterval is how often expired reservations are released
+	reservationSweepInterval = 5 * time.Second
+)
+
+// ErrReservationNotFound is returned for unknown, released, confirmed or
+// expired reservation tokens
+var ErrReservationNotFound = errors.New("reservation not found")
+
+// Reservation holds stock for a checkout until it is confirmed, released or expires
+type Reservation struct {
+	XMLName   xml.Name  `json:"-" xml:"reservation"`
+	Token     string    `json:"token" xml:"token"`
+	ProductID int32     `json:"productId" xml:"productId"`
+	Quantity  int32     `json:"quantity" xml:"quantity"`
+	ExpiresAt time.Time `json:"expiresAt" xml:"expiresAt"`
+}
+
+// ReservationManager tracks outstanding reservations against a Store. Reserved
+// units are taken out of the product's stock up front and handed back on
+// release or expiry.
+type ReservationManager struct {
+	mu           sync.Mutex
+	store        Store
+	ttl          time.Duration
+	reservations map[string]*Reservation
+	discarded    atomic.Int64 // released units whose product had been deleted
+}
+
+// NewReservationManager creates a manager whose reservations expire after ttl
+func NewReservationManager(store Store, ttl time.Duration) *ReservationManager {
+	return &ReservationManager{
+		store:        store,
+		ttl:          ttl,
+		reservations: make(map[string]*Reservation),
+	}
+}
+
+// ReserveStock takes qty units of a product's stock and returns the reservation holding them
+func (m *ReservationManager) ReserveStock(id int32, qty int32) (*Reservation, error) {
+	if _, err := m.store.DecrementStock(id, qty); err != nil {
+		return nil, err
+	}
+
+	reservation := &Reservation{
+		Token:     newRequestID(),
+		ProductID: id,
+		Quantity:  qty,
+		ExpiresAt: time.Now().Add(m.ttl),
+	}
+	m.mu.Lock()
+	m.reservations[reservation.Token] = reservation
+	m.mu.Unlock()
+	return reservation, nil
+}
+
+// ReleaseStock cancels a reservation and returns its units to the product
+func (m *ReservationManager) ReleaseStock(token string) (*Reservation, error) {
+	m.mu.Lock()
+	reservation, exists := m.reservations[token]
+	delete(m.reservations, token)
+	m.mu.Unlock()
+
+	if !exists {
+		return nil, ErrReservationNotFound
+	}
+	m.restock(reservation)
+	return reservation, nil
+}
+
+// ConfirmReservation completes a reservation, making the stock reduction permanent
+func (m *ReservationManager) ConfirmReservation(token string) (*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservation, exists := m.reservations[token]
+	// Expired reservations are left for the sweeper to restock
+	if !exists || !time.Now().Before(reservation.ExpiresAt) {
+		return nil, ErrReservationNotFound
+	}
+	delete(m.reservations, token)
+	return reservation, nil
+}
+
+// ReleaseAll returns the stock held by every outstanding reservation
+func (m *ReservationManager) ReleaseAll() {
+	m.release(func(*Reservation) bool { return true })
+}
+
+// releaseExpired returns the stock held by reservations that expired before now
+func (m *ReservationManager) releaseExpired(now time.Time) {
+	m.release(func(r *Reservation) bool { return !now.Before(r.ExpiresAt) })
+}
+
+// DropAll forgets every outstanding reservation without returning its stock,
+// for when the products it was taken from have been replaced wholesale
+func (m *ReservationManager) DropAll() {
+	m.remove(func(*Reservation) bool { return true })
+}
+
+// DropProducts forgets the reservations against the products with ids
+// without returning their stock, for when those products have been overwritten
+func (m *ReservationManager) DropProducts(ids []int32) {
+	dropped := make(map[int32]bool, len(ids))
+	for _, id := range ids {
+		dropped[id] = true
+	}
+	m.remove(func(r *Reservation) bool { return dropped[r.ProductID] })
+}
+
+// release removes the reservations matching pred, then restocks them outside
+// the lock so the store is never called while holding m.mu
+func (m *ReservationManager) release(pred func(*Reservation) bool) {
+	for _, reservation := range m.remove(pred) {
+		slog.Debug("Releasing reservation", "token", reservation.Token, "id", reservation.ProductID, "quantity", reservation.Quantity)
+		m.restock(reservation)
+	}
+}
+
+// remove deletes and returns the reservations matching pred
+func (m *ReservationManager) remove(pred func(*Reservation) bool) []*Reservation {
+	var removed []*Reservation
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, reservation := range m.reservations {
+		if pred(reservation) {
+			delete(m.reservations, token)
+			removed = append(removed, reservation)
+		}
+	}
+	return removed
+}
+
+// restock returns a reservation's units to its product. A product deleted
+// while reserved has nothing to return them to, so the units are logged and
+// counted as discarded rather than silently dropped; restoring the product
+// later does not bring them back.
+func (m *ReservationManager) restock(reservation *Reservation) {
+	_, err := m.store.IncrementStock(reservation.ProductID, reservation.Quantity)
+	switch {
+	case errors.Is(err, ErrProductNotFound):
+		m.discarded.Add(int64(reservation.Quantity))
+		slog.Warn("Discarding released stock of a deleted product", "token", reservation.Token, "id", reservation.ProductID, "quantity", reservation.Quantity)
+	case err != nil:
+		slog.Error("Error releasing reservation", "token", reservation.Token, "id", reservation.ProductID, "error", err)
+	}
+}
+
+// DiscardedUnits returns how many released units could not be returned
+// because their product had been deleted
+func (m *ReservationManager) DiscardedUnits() int64 {
+	return m.discarded.Load()
+}
+
+// RunExpiry periodically releases expired reservations until stop is closed
+func (m *ReservationManager) RunExpiry(stop <-chan struct{}) {
+	ticker := time.NewTicker(reservationSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.releaseExpired(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// dropReservationsOnReset forgets outstanding reservations whenever the store
+// is reset. Their products are gone, and handing the units back would add
+// them to whichever new product reuses the ID.
+func (s *Server) dropReservationsOnReset() {
+	s.observeStore(func(event ProductEvent) {
+		if event.Type == EventReset {
+			s.reservations.DropAll()
+		}
+	})
+}
+
+// HandleReserveStock handles POST /products/{productId}/reserve
+func (s *Server) HandleReserveStock(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseProductID(w, r)
+	if !ok {
+		return
+	}
+
+	var req PurchaseRequest
+	if !s.decodeJSONBody(w, r, &req) {
+		return
+	}
+	if !s.validQuantity(w, r, req.Quantity) {
+		return
+	}
+
+	reservation, err := s.reservations.ReserveStock(productID, req.Quantity)
+	if err != nil {
+		writeStoreError(w, r, productID, err)
+		return
+	}
+	s.recordAudit(r, AuditUpdate, productID)
+	writeResponse(w, r, http.StatusCreated, reservation)
+}
+
+// HandleReleaseReservation handles DELETE /reservations/{token}
+func (s *Server) HandleReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, err := s.reservations.ReleaseStock(mux.Vars(r)["token"])
+	if err != nil {
+		writeErrorResponse(w, r, http.StatusNotFound, ErrCodeReservationNotFound, "Reservation not found")
+		return
+	}
+	s.recordAudit(r, AuditUpdate, reservation.ProductID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleConfirmReservation handles POST /reservations/{token}/confirm
+func (s *Server) HandleConfirmReservation(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.reservations.ConfirmReservation(mux.Vars(r)["token"]); err != nil {
+		writeErrorResponse(w, r, http.StatusNotFound, ErrCodeReservationNotFound, "Reservation not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// reserve reserves qty units of product id through h and returns the reservation
+func reserve(t *testing.T, h http.Handler, id string, qty string) Reservation {
+	t.Helper()
+	rec := serve(h, "POST", "/v1/products/"+id+"/reserve", `{"quantity":`+qty+`}`)
+	assertStatus(t, rec, http.StatusCreated)
+	return decodeBody[Reservation](t, rec)
+}
+
+// assertStock fails the test unless product id has the wanted stock
+func assertStock(t *testing.T, h http.Handler, id string, want int32) {
+	t.Helper()
+	if product := getProduct(t, h, id); product.Stock != want {
+		t.Errorf("product %s stock = %d, want %d", id, product.Stock, want)
+	}
+}
+
+func TestReservationLifecycle(t *testing.T) {
+	server, h := newTestServer(t)
+
+	released := reserve(t, h, "1", "3")
+	assertStock(t, h, "1", 7)
+	assertStatus(t, serve(h, "DELETE", "/v1/reservations/"+released.Token, ""), http.StatusNoContent)
+	assertStock(t, h, "1", 10)
+	assertError(t, serve(h, "DELETE", "/v1/reservations/"+released.Token, ""), http.StatusNotFound, ErrCodeReservationNotFound)
+
+	confirmed := reserve(t, h, "1", "4")
+	assertStatus(t, serve(h, "POST", "/v1/reservations/"+confirmed.Token+"/confirm", ""), http.StatusNoContent)
+	assertError(t, serve(h, "DELETE", "/v1/reservations/"+confirmed.Token, ""), http.StatusNotFound, ErrCodeReservationNotFound)
+	assertStock(t, h, "1", 6)
+
+	reserve(t, h, "1", "2")
+	server.reservations.releaseExpired(time.Now())
+	assertStock(t, h, "1", 4)
+	server.reservations.releaseExpired(time.Now().Add(server.reservations.ttl))
+	assertStock(t, h, "1", 6)
+
+	assertError(t, serve(h, "POST", "/v1/products/1/reserve", `{"quantity":7}`), http.StatusConflict, ErrCodeInsufficientStock)
+}
+
+func TestReleaseReservationOfDeletedProduct(t *testing.T) {
+	for backend, configure := range storeBackends(t) {
+		t.Run(backend, func(t *testing.T) {
+			server, h := newTestServer(t, configure)
+			released := reserve(t, h, "1", "3")
+			reserve(t, h, "1", "2")
+			assertStatus(t, serve(h, "DELETE", "/v1/products/1", ""), http.StatusNoContent)
+
+			// Releasing still succeeds, but the units have no product to go back to
+			assertStatus(t, serve(h, "DELETE", "/v1/reservations/"+released.Token, ""), http.StatusNoContent)
+			server.reservations.releaseExpired(time.Now().Add(server.reservations.ttl))
+			if got := server.reservations.DiscardedUnits(); got != 5 {
+				t.Errorf("discarded units = %d, want 5", got)
+			}
+			assertStatus(t, serve(h, "POST", "/v1/products/1/restore", ""), http.StatusOK)
+			assertStock(t, h, "1", 5)
+		})
+	}
+}
+
+func TestResetDropsReservations(t *testing.T) {
+	server, h := newTestServer(t)
+	reservation := reserve(t, h, "1", "3")
+
+	assertStatus(t, serve(h, "DELETE", "/v1/products", ""), http.StatusNoContent)
+	assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Fresh","price":1,"stock":5}`), http.StatusCreated)
+
+	// The new product 1 must not receive the old product's reserved units
+	assertError(t, serve(h, "DELETE", "/v1/reservations/"+reservation.Token, ""), http.StatusNotFound, ErrCodeReservationNotFound)
+	server.reservations.ReleaseAll()
+	assertStock(t, h, "1", 5)
+}
+
+func TestImportDropsReservations(t *testing.T) {
+	const laptop = `{"id":1,"name":"Laptop","price":999.99,"stock":20}`
+	tests := []struct {
+		name         string
+		mode         string
+		wantMouse    int32 // product 2's stock after releasing its reservation
+		mouseDropped bool
+	}{
+		{"replace drops every reservation", "replace", 0, true},
+		{"merge drops reservations on imported products", "merge", 50, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			onLaptop := reserve(t, h, "1", "3")
+			onMouse := reserve(t, h, "2", "5")
+
+			rec := serve(h, "POST", "/v1/import?mode="+tt.mode, `{"nextId":4,"products":[`+laptop+`]}`)
+			assertStatus(t, rec, http.StatusOK)
+
+			assertError(t, serve(h, "DELETE", "/v1/reservations/"+onLaptop.Token, ""), http.StatusNotFound, ErrCodeReservationNotFound)
+			assertStock(t, h, "1", 20)
+
+			rec = serve(h, "DELETE", "/v1/reservations/"+onMouse.Token, "")
+			if tt.mouseDropped {
+				assertError(t, rec, http.StatusNotFound, ErrCodeReservationNotFound)
+				return
+			}
+			assertStatus(t, rec, http.StatusNoContent)
+			assertStock(t, h, "2", tt.wantMouse)
+		})
+	}
+}
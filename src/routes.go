@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// apiVersionV1 is the path segment and context value for version 1 of the API.
+// A future v2 gets its own constant and register function, mounted alongside v1.
+const apiVersionV1 = "v1"
+
+// apiVersionKey is the context key under which the API version is stored
+type apiVersionKey struct{}
+
+// APIVersionMiddleware records which API version a request was routed to so
+// handlers shared between versions can diverge where needed
+func APIVersionMiddleware(version string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), apiVersionKey{}, version)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// APIVersionFromContext returns the API version stored on ctx, or "" if none
+func APIVersionFromContext(ctx context.Context) string {
+	version, _ := ctx.Value(apiVersionKey{}).(string)
+	return version
+}
+
+// mountAPIVersion creates the subrouter for version under parent and tags
+// its requests with the version
+func mountAPIVersion(parent *mux.Router, version string) *mux.Router {
+	sub := parent.PathPrefix("/" + version).Subrouter()
+	sub.Use(APIVersionMiddleware(version))
+
+	// mux loses a subrouter's method mismatch once a later route matches the
+	// shared prefix, so the 404 handler decides between 404 and 405 itself
+	methodNotAllowed := MethodNotAllowedHandler(sub)
+	sub.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(allowedMethods(sub, r)) > 0 {
+			methodNotAllowed.ServeHTTP(w, r)
+			return
+		}
+		NotFoundHandler(w, r)
+	})
+	return sub
+}
+
+// registerV1Routes registers the version 1 product endpoints on r. Product
+// reads also answer HEAD, which net/http serves with GET's headers and no body.
+func (s *Server) registerV1Routes(r *mux.Router) {
+	r.HandleFunc("/products", s.HandleListProducts).Methods("GET", "HEAD").Name(routeListProducts)
+	r.HandleFunc("/products", s.Idempotent(s.HandleCreateProduct)).Methods("POST")
+	r.HandleFunc("/products", s.HandleResetProducts).Methods("DELETE")
+	r.HandleFunc("/products/batch", s.Idempotent(s.HandleBatchCreate)).Methods("POST")
+	r.HandleFunc("/products/batch-update", s.HandleBatchUpdate).Methods("POST")
+	r.HandleFunc("/products.csv", s.HandleExportCSV).Methods("GET")
+	r.HandleFunc("/products/value", s.HandleInventoryValue).Methods("GET")
+	r.HandleFunc("/products/compare", s.HandleCompareProducts).Methods("GET")
+	r.HandleFunc("/products/events", s.HandleProductEvents).Methods("GET").Name(routeProductEvents)
+	r.HandleFunc("/products/import", s.HandleImportCSV).Methods("POST")
+	r.HandleFunc("/products/{productId:[0-9]+}", s.HandleGetProduct).Methods("GET", "HEAD")
+	r.HandleFunc("/products/{productId:[0-9]+}/details", s.HandleAddProductDetails).Methods("POST")
+	r.HandleFunc("/products/{productId:[0-9]+}/purchase", s.HandlePurchase).Methods("POST")
+	r.HandleFunc("/products/{productId:[0-9]+}/stock", s.HandleGetStock).Methods("GET", "HEAD")
+	r.HandleFunc("/products/{productId:[0-9]+}/stock/adjust", s.HandleAdjustStock).Methods("POST")
+	r.HandleFunc("/products/{productId:[0-9]+}/reserve", s.HandleReserveStock).Methods("POST")
+	r.HandleFunc("/products/{productId:[0-9]+}", s.HandleReplaceProduct).Methods("PUT")
+	r.HandleFunc("/products/{productId:[0-9]+}", s.HandleDeleteProduct).Methods("DELETE")
+	r.HandleFunc("/products/{productId:[0-9]+}/restore", s.HandleRestoreProduct).Methods("POST")
+	r.HandleFunc("/products/{productId:[0-9]+}/duplicate", s.HandleDuplicateProduct).Methods("POST")
+	r.HandleFunc("/products/{productId:[0-9]+}/history", s.HandleProductHistory).Methods("GET")
+	r.HandleFunc("/categories", s.HandleListCategories).Methods("GET")
+	r.HandleFunc("/reservations/{token}", s.HandleReleaseReservation).Methods("DELETE")
+	r.HandleFunc("/reservations/{token}/confirm", s.HandleConfirmReservation).Methods("POST")
+
+	// Whole-store JSON backup and restore
+	r.HandleFunc("/export", s.HandleExport).Methods("GET")
+	r.HandleFunc("/import", s.HandleImport).Methods("POST")
+
+	// Audit log of recent mutations
+	r.HandleFunc("/audit", s.HandleAudit).Methods("GET")
+}
+
+// Handler builds the server's complete HTTP handler as configured by cfg:
+// every route with its middleware, wrapped in CORS and in-flight counting.
+// Requests are rate limited through rateLimiter, whose idle buckets the
+// caller is responsible for evicting.
+func (s *Server) Handler(cfg Config, rateLimiter *RateLimiter) http.Handler {
+	router := mux.NewRouter()
+
+	// Apply middleware
+	router.Use(RequestIDMiddleware)
+	router.Use(ClientIPMiddleware(cfg.TrustedProxies))
+	router.Use(LoggingMiddleware)
+	router.Use(RecoveryMiddleware(cfg.Debug))
+	router.Use(QueryLimitMiddleware(cfg.MaxQueryBytes, cfg.MaxQueryParams))
+	router.Use(RequestDecodingMiddleware(cfg.RequestEncodings))
+	router.Use(GzipMiddleware)
+	router.Use(rateLimiter.Middleware)
+
+	// API key authentication for mutating requests
+	if cfg.APIKey != "" {
+		router.Use(AuthMiddleware(cfg.APIKey))
+	}
+	router.Use(TimeoutMiddleware(cfg.RequestTimeout))
+
+	// Return JSON errors for unknown paths and unsupported methods
+	router.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
+	router.MethodNotAllowedHandler = MethodNotAllowedHandler(router)
+
+	// Prometheus metrics are opt-in
+	if cfg.EnableMetrics {
+		router.Use(MetricsMiddleware)
+		router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+		slog.Info("Metrics enabled", "path", "/metrics")
+	}
+
+	// Versioned product routes live under API_PREFIX; health, readiness and
+	// metrics stay unversioned at the root for load balancers and scrapers
+	api := router
+	if cfg.APIPrefix != "" {
+		api = router.PathPrefix(cfg.APIPrefix).Subrouter()
+	}
+	v1 := mountAPIVersion(api, apiVersionV1)
+	v1.Use(s.ReadinessMiddleware)
+	v1.Use(s.MaintenanceMiddleware)
+	s.registerV1Routes(v1)
+
+	// Health check endpoint (useful for ECS)
+	router.HandleFunc("/health", s.HandleHealth).Methods("GET")
+
+	// API documentation
+	router.HandleFunc("/openapi.json", HandleOpenAPISpec).Methods("GET")
+	router.HandleFunc("/docs", HandleDocs).Methods("GET")
+
+	// Quick operator view of store contents
+	router.HandleFunc("/stats", s.HandleStats).Methods("GET")
+
+	// Readiness endpoint, distinct from the liveness check above
+	router.HandleFunc("/ready", s.HandleReady).Methods("GET")
+
+	// Maintenance mode rejects product writes; the toggle itself stays
+	// outside the versioned API so it keeps working while enabled
+	router.HandleFunc("/admin/maintenance", s.HandleGetMaintenance).Methods("GET")
+	router.HandleFunc("/admin/maintenance", s.HandleSetMaintenance).Methods("POST")
+
+	// CORS wraps the whole router so preflight requests are answered even
+	// though no route is registered for OPTIONS
+	return CORSMiddleware(cfg.AllowedOrigins)(s.InFlightMiddleware(router))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestVersionedRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{"v1 product", "", "GET", "/v1/products/1", http.StatusOK},
+		{"v1 list", "", "GET", "/v1/products", http.StatusOK},
+		{"unversioned product", "", "GET", "/products/1", http.StatusNotFound},
+		{"unknown version", "", "GET", "/v2/products/1", http.StatusNotFound},
+		{"v1 wrong method", "", "PATCH", "/v1/products/1", http.StatusMethodNotAllowed},
+		{"health is unversioned", "", "GET", "/health", http.StatusOK},
+		{"no versioned health", "", "GET", "/v1/health", http.StatusNotFound},
+		{"v1 under prefix", "/api", "GET", "/api/v1/products/1", http.StatusOK},
+		{"v1 without prefix", "/api", "GET", "/v1/products/1", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) { cfg.APIPrefix = tt.prefix })
+			assertStatus(t, serve(h, tt.method, tt.target, ""), tt.wantStatus)
+		})
+	}
+}
+
+func TestAPIVersionFromContext(t *testing.T) {
+	router := mux.NewRouter()
+	var got string
+	mountAPIVersion(router, apiVersionV1).HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		got = APIVersionFromContext(r.Context())
+	})
+	assertStatus(t, serve(router, "GET", "/v1/version", ""), http.StatusOK)
+	if got != apiVersionV1 {
+		t.Errorf("APIVersionFromContext = %q, want %q", got, apiVersionV1)
+	}
+}
+
+func TestCreateLocationIsVersioned(t *testing.T) {
+	_, h := newTestServer(t, func(cfg *Config) { cfg.APIPrefix = "/api" })
+	rec := serve(h, "POST", "/api/v1/products", `{"name":"Widget","price":1,"stock":1}`)
+	assertStatus(t, rec, http.StatusCreated)
+	if got := rec.Header().Get("Location"); got != "/api/v1/products/4" {
+		t.Errorf("Location = %q, want /api/v1/products/4", got)
+	}
+}
+
+func TestAllowHeader(t *testing.T) {
+	tests := []struct {
+		method    string
+		target    string
+		wantAllow string
+	}{
+		{"PATCH", "/v1/products/1", "GET, HEAD, PUT, DELETE"},
+		{"PATCH", "/v1/products", "GET, HEAD, POST, DELETE"},
+		{"PATCH", "/v1/products/1/stock", "GET, HEAD"},
+		{"GET", "/v1/products/1/purchase", "POST"},
+		{"PUT", "/health", "GET"},
+		{"DELETE", "/admin/maintenance", "GET, POST"},
+	}
+	_, h := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rec := serve(h, tt.method, tt.target, "")
+			assertError(t, rec, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed)
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	_, h := newTestServer(t)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		header     string // a header GET sets that HEAD must repeat
+	}{
+		{"/v1/products/1", http.StatusOK, "ETag"},
+		{"/v1/products/1/stock", http.StatusOK, "Content-Type"},
+		{"/v1/products?limit=2", http.StatusOK, "X-Total-Count"},
+		{"/v1/products/99", http.StatusNotFound, "Content-Type"},
+		{"/v1/products/0", http.StatusBadRequest, "Content-Type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			get, err := ts.Client().Get(ts.URL + tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			getBody, _ := io.ReadAll(get.Body)
+			get.Body.Close()
+
+			head, err := ts.Client().Head(ts.URL + tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			headBody, _ := io.ReadAll(head.Body)
+			head.Body.Close()
+
+			if head.StatusCode != tt.wantStatus || get.StatusCode != tt.wantStatus {
+				t.Fatalf("HEAD = %d, GET = %d, want %d", head.StatusCode, get.StatusCode, tt.wantStatus)
+			}
+			if len(headBody) != 0 {
+				t.Errorf("HEAD body = %q, want empty", headBody)
+			}
+			if len(getBody) == 0 {
+				t.Error("GET body is empty")
+			}
+			if got, want := head.Header.Get(tt.header), get.Header.Get(tt.header); got == "" || got != want {
+				t.Errorf("HEAD %s = %q, want GET's %q", tt.header, got, want)
+			}
+			if head.ContentLength != get.ContentLength {
+				t.Errorf("HEAD Content-Length = %d, want GET's %d", head.ContentLength, get.ContentLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,681 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go driver, works with CGO_ENABLED=0
+)
+
+// SQLiteStore persists products in a SQLite database
+type SQLiteStore struct {
+	db      *sql.DB
+	firstID int32 // where IDs start, and restart after Reset
+}
+
+// NewSQLiteStore opens (or creates) the database at path and ensures the schema exists
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening sqlite database: %w", err)
+	}
+	// SQLite allows a single writer; serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	const schema = `CREATE TABLE IF NOT EXISTS products (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		name        TEXT    NOT NULL,
+		description TEXT    NOT NULL DEFAULT '',
+		price_cents INTEGER NOT NULL,
+		currency    TEXT    NOT NULL DEFAULT '',
+		stock       INTEGER NOT NULL,
+		category    TEXT    NOT NULL DEFAULT '',
+		categories  TEXT    NOT NULL DEFAULT '[]',
+		image_url   TEXT    NOT NULL DEFAULT '',
+		version     INTEGER NOT NULL DEFAULT 1,
+		deleted     INTEGER NOT NULL DEFAULT 0,
+		deleted_at  TEXT,
+		created_at  TEXT    NOT NULL DEFAULT '',
+		updated_at  TEXT    NOT NULL DEFAULT ''
+	)`
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating products table: %w", err)
+	}
+	// Databases created by earlier releases lack the newer columns
+	migrations := []struct{ column, definition string }{
+		{"version", "INTEGER NOT NULL DEFAULT 1"},
+		{"deleted", "INTEGER NOT NULL DEFAULT 0"},
+		{"deleted_at", "TEXT"},
+		{"updated_at", "TEXT NOT NULL DEFAULT ''"},
+		{"currency", "TEXT NOT NULL DEFAULT ''"},
+		{"categories", "TEXT NOT NULL DEFAULT '[]'"},
+		{"created_at", "TEXT NOT NULL DEFAULT ''"},
+		{"price_cents", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, m := range migrations {
+		if err := ensureColumn(db, "products", m.column, m.definition); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("migrating products table: %w", err)
+		}
+	}
+	// Rows from before updated_at existed count as modified now, and rows
+	// from before created_at as created at their last modification
+	if _, err := db.Exec("UPDATE products SET updated_at = ? WHERE updated_at = ''", sqliteNow()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating products table: %w", err)
+	}
+	if _, err := db.Exec("UPDATE products SET created_at = updated_at WHERE created_at = ''"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating products table: %w", err)
+	}
+	if err := migratePriceCents(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating products table: %w", err)
+	}
+	return &SQLiteStore{db: db, firstID: 1}, nil
+}
+
+// migratePriceCents moves prices from the REAL price column used by earlier
+// releases into price_cents, rounding each to the nearest cent, and drops the
+// old column
+func migratePriceCents(db *sql.DB) error {
+	exists, err := hasColumn(db, "products", "price")
+	if err != nil || !exists {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE products SET price_cents = CAST(ROUND(price * 100) AS INTEGER)"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("ALTER TABLE products DROP COLUMN price"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureColumn adds column to table with the given definition if it is missing
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// hasColumn reports whether table has a column named column
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// SetFirstID makes IDs start at id instead of 1. The AUTOINCREMENT counter only
+// ever moves forward, so IDs already handed out are never reused.
+func (s *SQLiteStore) SetFirstID(id int32) error {
+	s.firstID = id
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := raiseSequence(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// raiseSequence moves the products AUTOINCREMENT counter so the next ID
+// assigned is at least next
+func raiseSequence(tx *sql.Tx, next int32) error {
+	if _, err := tx.Exec("INSERT INTO sqlite_sequence (name, seq) SELECT 'products', 0 WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'products')"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = 'products'", next-1)
+	return err
+}
+
+// Close releases the underlying database handle
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database can still be queried
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	var n int
+	return s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM products WHERE id = 0").Scan(&n)
+}
+
+const productColumns = "id, name, description, price_cents, currency, stock, category, categories, image_url, version, deleted, deleted_at, created_at, updated_at"
+
+// sqliteNow returns the current time in the format timestamps are stored in
+func sqliteNow() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// productCategoriesQuery selects one (id, category) row per live product and
+// category it carries, from both the category column and the categories tags
+const productCategoriesQuery = `SELECT id, category FROM products WHERE deleted = 0 AND category != ''
+	UNION SELECT products.id, json_each.value FROM products, json_each(products.categories) WHERE deleted = 0 AND json_each.value != ''`
+
+// encodeCategories encodes category tags for the categories column
+func encodeCategories(categories []string) string {
+	if len(categories) == 0 {
+		return "[]"
+	}
+	data, _ := json.Marshal(categories)
+	return string(data)
+}
+
+// scanProduct reads a product from a row selected with productColumns
+func scanProduct(row interface{ Scan(...interface{}) error }) (*Product, error) {
+	var p Product
+	var deletedAt sql.NullString
+	var createdAt, updatedAt, categories string
+	var price int64
+	if err := row.Scan(&p.ID, &p.Name, &p.Description, &price, &p.Currency, &p.Stock, &p.Category, &categories, &p.ImageURL, &p.Version, &p.Deleted, &deletedAt, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, updatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("parsing updated_at: %w", err)
+	}
+	p.UpdatedAt = t
+	if p.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, fmt.Errorf("parsing created_at: %w", err)
+	}
+	p.Price = Cents(price)
+	if err := json.Unmarshal([]byte(categories), &p.Categories); err != nil {
+		return nil, fmt.Errorf("parsing categories: %w", err)
+	}
+	if len(p.Categories) == 0 {
+		p.Categories = nil
+	}
+	if deletedAt.Valid {
+		t, err := time.Parse(time.RFC3339Nano, deletedAt.String)
+		if err != nil {
+			return nil, fmt.Errorf("parsing deleted_at: %w", err)
+		}
+		p.DeletedAt = &t
+	}
+	return &p, nil
+}
+
+// GetProduct retrieves a product by ID, returning ErrProductNotFound when
+// there is no live row for it
+func (s *SQLiteStore) GetProduct(id int32) (*Product, error) {
+	row := s.db.QueryRow("SELECT "+productColumns+" FROM products WHERE id = ? AND deleted = 0", id)
+	product, err := scanProduct(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	return product, err
+}
+
+// GetProductIncludingDeleted retrieves a product by ID whether or not it is
+// soft-deleted; callers tell the two apart by Deleted
+func (s *SQLiteStore) GetProductIncludingDeleted(id int32) (*Product, error) {
+	product, err := scanProduct(s.db.QueryRow("SELECT "+productColumns+" FROM products WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	return product, err
+}
+
+// GetProducts retrieves the products with the given IDs in a single query,
+// in the order given, skipping missing ones
+func (s *SQLiteStore) GetProducts(ids []int32) []*Product {
+	if len(ids) == 0 {
+		return []*Product{}
+	}
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	byID := make(map[int32]*Product, len(ids))
+	for _, product := range s.listProducts("SELECT "+productColumns+" FROM products WHERE deleted = 0 AND id IN ("+strings.Join(placeholders, ", ")+")", args...) {
+		byID[product.ID] = product
+	}
+
+	products := make([]*Product, 0, len(byID))
+	for _, id := range ids {
+		if product, exists := byID[id]; exists {
+			products = append(products, product)
+		}
+	}
+	return products
+}
+
+// AddOrUpdateProduct updates an existing product, preserving the ID
+func (s *SQLiteStore) AddOrUpdateProduct(id int32, product *Product) (*Product, error) {
+	return s.UpdateProduct(id, product, 0)
+}
+
+// UpdateProduct replaces an existing product and returns it as stored,
+// requiring the stored version to equal expectedVersion when it is non-zero
+func (s *SQLiteStore) UpdateProduct(id int32, product *Product, expectedVersion int) (*Product, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	version, err := updateRow(tx, id, product, expectedVersion, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	markUpdated(id, product, version, now)
+	return product, nil
+}
+
+// UpdateProducts applies several independent updates in a single transaction.
+// Missing products and version conflicts fail only their own update; any
+// other error fails them all.
+func (s *SQLiteStore) UpdateProducts(updates []ProductUpdate) []error {
+	errs := make([]error, len(updates))
+	fail := func(err error) []error {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	versions := make([]int, len(updates))
+	for i, u := range updates {
+		versions[i], errs[i] = updateRow(tx, u.ID, u.Product, u.ExpectedVersion, now)
+		if errs[i] != nil && !errors.Is(errs[i], ErrProductNotFound) && !errors.Is(errs[i], ErrVersionConflict) {
+			return fail(errs[i])
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+	for i, u := range updates {
+		if errs[i] == nil {
+			markUpdated(u.ID, u.Product, versions[i], now)
+		}
+	}
+	return errs
+}
+
+// updateRow replaces the live product with id inside tx, returning its new
+// version. The product's creation time is carried over from the row.
+func updateRow(tx *sql.Tx, id int32, product *Product, expectedVersion int, now string) (int, error) {
+	var version int
+	var createdAt string
+	if err := tx.QueryRow("SELECT version, created_at FROM products WHERE id = ? AND deleted = 0", id).Scan(&version, &createdAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrProductNotFound
+		}
+		return 0, err
+	}
+	product.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	if expectedVersion != 0 && version != expectedVersion {
+		return 0, ErrVersionConflict
+	}
+	_, err := tx.Exec(
+		"UPDATE products SET name = ?, description = ?, price_cents = ?, currency = ?, stock = ?, category = ?, categories = ?, image_url = ?, version = ?, updated_at = ? WHERE id = ?",
+		product.Name, product.Description, int64(product.Price), product.Currency, product.Stock, product.Category, encodeCategories(product.Categories), product.ImageURL, version+1, now, id)
+	if err != nil {
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+// markUpdated fills in the stored fields of a product written by updateRow
+func markUpdated(id int32, product *Product, version int, now string) {
+	product.ID = id
+	product.Version = version
+	product.Deleted, product.DeletedAt = false, nil
+	product.UpdatedAt, _ = time.Parse(time.RFC3339Nano, now)
+}
+
+// CreateProduct inserts a new product and assigns its ID
+func (s *SQLiteStore) CreateProduct(product *Product) (*Product, error) {
+	created, err := s.CreateProducts([]*Product{product})
+	if err != nil {
+		return nil, err
+	}
+	return created[0], nil
+}
+
+// CreateProducts inserts several products in a single transaction, assigning
+// their IDs. On error none of them is stored.
+func (s *SQLiteStore) CreateProducts(products []*Product) ([]*Product, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT INTO products (name, description, price_cents, currency, stock, category, categories, image_url, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	now := sqliteNow()
+	for _, product := range products {
+		res, err := stmt.Exec(product.Name, product.Description, int64(product.Price), product.Currency, product.Stock, product.Category, encodeCategories(product.Categories), product.ImageURL, now, now)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		product.ID = int32(id)
+		product.Version = 1
+		product.Deleted, product.DeletedAt = false, nil
+		product.UpdatedAt, _ = time.Parse(time.RFC3339Nano, now)
+		product.CreatedAt = product.UpdatedAt
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// DecrementStock atomically reduces a product's stock by qty, returning the
+// updated product
+func (s *SQLiteStore) DecrementStock(id int32, qty int32) (*Product, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var stock int32
+	if err := tx.QueryRow("SELECT stock FROM products WHERE id = ? AND deleted = 0", id).Scan(&stock); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	if stock < qty {
+		return nil, ErrInsufficientStock
+	}
+	product, err := scanProduct(tx.QueryRow("UPDATE products SET stock = stock - ?, version = version + 1, updated_at = ? WHERE id = ? RETURNING "+productColumns, qty, sqliteNow(), id))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// IncrementStock atomically returns qty units to a product's stock
+func (s *SQLiteStore) IncrementStock(id int32, qty int32) (*Product, error) {
+	product, err := scanProduct(s.db.QueryRow("UPDATE products SET stock = stock + ?, version = version + 1, updated_at = ? WHERE id = ? AND deleted = 0 RETURNING "+productColumns, qty, sqliteNow(), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	return product, err
+}
+
+// AdjustStock atomically applies a signed delta to a product's stock
+func (s *SQLiteStore) AdjustStock(id int32, delta int32, limit int32) (*Product, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var stock int32
+	if err := tx.QueryRow("SELECT stock FROM products WHERE id = ? AND deleted = 0", id).Scan(&stock); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	next, err := adjustedStock(stock, delta, limit)
+	if err != nil {
+		return nil, err
+	}
+	product, err := scanProduct(tx.QueryRow("UPDATE products SET stock = ?, version = version + 1, updated_at = ? WHERE id = ? RETURNING "+productColumns, next, sqliteNow(), id))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// DeleteProduct soft-deletes a product by ID, keeping the row restorable
+func (s *SQLiteStore) DeleteProduct(id int32) error {
+	now := sqliteNow()
+	res, err := s.db.Exec("UPDATE products SET deleted = 1, deleted_at = ?, version = version + 1, updated_at = ? WHERE id = ? AND deleted = 0", now, now, id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(res)
+}
+
+// RestoreProduct undeletes a soft-deleted product and returns it
+func (s *SQLiteStore) RestoreProduct(id int32) (*Product, error) {
+	product, err := scanProduct(s.db.QueryRow("UPDATE products SET deleted = 0, deleted_at = NULL, version = version + 1, updated_at = ? WHERE id = ? AND deleted = 1 RETURNING "+productColumns, sqliteNow(), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	return product, err
+}
+
+// requireRowAffected returns ErrProductNotFound unless res changed a row
+func requireRowAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+// ListProducts returns all products that are not soft-deleted, sorted by ID ascending
+func (s *SQLiteStore) ListProducts() []*Product {
+	return s.listProducts("SELECT " + productColumns + " FROM products WHERE deleted = 0 ORDER BY id")
+}
+
+// ListAllProducts is ListProducts including soft-deleted products
+func (s *SQLiteStore) ListAllProducts() []*Product {
+	return s.listProducts("SELECT " + productColumns + " FROM products ORDER BY id")
+}
+
+// listProducts returns the products selected by query
+func (s *SQLiteStore) listProducts(query string, args ...interface{}) []*Product {
+	products := []*Product{}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		slog.Error("Error listing products", "error", err)
+		return products
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			slog.Error("Error reading product row", "error", err)
+			continue
+		}
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error listing products", "error", err)
+	}
+	return products
+}
+
+// ListCategories returns the distinct non-empty categories with product counts
+func (s *SQLiteStore) ListCategories() []CategoryCount {
+	counts := make(map[string]int)
+	rows, err := s.db.Query("SELECT category, COUNT(*) FROM (" + productCategoriesQuery + ") GROUP BY category")
+	if err != nil {
+		slog.Error("Error listing categories", "error", err)
+		return sortedCategoryCounts(counts)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category string
+		var count int
+		if err := rows.Scan(&category, &count); err != nil {
+			slog.Error("Error reading category row", "error", err)
+			continue
+		}
+		counts[category] = count
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error listing categories", "error", err)
+	}
+	return sortedCategoryCounts(counts)
+}
+
+// Stats computes summary counts in a single query. The next ID comes from the
+// AUTOINCREMENT counter, so it reflects deleted rows just like the memory store.
+func (s *SQLiteStore) Stats() StoreStats {
+	var stats StoreStats
+	err := s.db.QueryRow(`SELECT COUNT(*), (SELECT COUNT(*) FROM products WHERE deleted = 1), COALESCE(SUM(stock), 0),
+		(SELECT COUNT(DISTINCT category) FROM (`+productCategoriesQuery+`)),
+		(SELECT COALESCE(MAX(seq), 0) + 1 FROM sqlite_sequence WHERE name = 'products')
+		FROM products WHERE deleted = 0`).Scan(&stats.Products, &stats.Deleted, &stats.TotalStock, &stats.Categories, &stats.NextID)
+	if err != nil {
+		slog.Error("Error computing stats", "error", err)
+	}
+	return stats
+}
+
+// Reset removes all products and restarts IDs at the first ID. This is destructive and
+// intended primarily for test environments.
+func (s *SQLiteStore) Reset() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM products"); err != nil {
+		return err
+	}
+	// AUTOINCREMENT keeps its counter in sqlite_sequence
+	if _, err := tx.Exec("DELETE FROM sqlite_sequence WHERE name = 'products'"); err != nil {
+		return err
+	}
+	if err := raiseSequence(tx, s.firstID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Snapshot returns every product, including soft-deleted ones, sorted by ID,
+// along with the next ID, read in a single transaction
+func (s *SQLiteStore) Snapshot() (storeSnapshot, error) {
+	snapshot := storeSnapshot{Products: []*Product{}}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return snapshot, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT " + productColumns + " FROM products ORDER BY id")
+	if err != nil {
+		return snapshot, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return snapshot, err
+		}
+		snapshot.Products = append(snapshot.Products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return snapshot, err
+	}
+	err = tx.QueryRow("SELECT COALESCE(MAX(seq), 0) + 1 FROM sqlite_sequence WHERE name = 'products'").Scan(&snapshot.NextID)
+	return snapshot, err
+}
+
+// Import loads snapshot in a single transaction. With replace every existing
+// row is deleted first; otherwise snapshot products overwrite rows with the
+// same ID and the rest are kept. The ID sequence never moves backwards.
+func (s *SQLiteStore) Import(snapshot storeSnapshot, replace bool) error {
+	nextID, err := snapshot.prepare(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		if _, err := tx.Exec("DELETE FROM products"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM sqlite_sequence WHERE name = 'products'"); err != nil {
+			return err
+		}
+	}
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO products (" + productColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range snapshot.Products {
+		var deletedAt sql.NullString
+		if p.DeletedAt != nil {
+			deletedAt = sql.NullString{String: p.DeletedAt.UTC().Format(time.RFC3339Nano), Valid: true}
+		}
+		if _, err := stmt.Exec(p.ID, p.Name, p.Description, int64(p.Price), p.Currency, p.Stock, p.Category, encodeCategories(p.Categories), p.ImageURL, p.Version, p.Deleted, deletedAt, p.CreatedAt.UTC().Format(time.RFC3339Nano), p.UpdatedAt.UTC().Format(time.RFC3339Nano)); err != nil {
+			return err
+		}
+	}
+
+	// Explicit IDs advance the AUTOINCREMENT counter to the highest one; move
+	// it further when the snapshot's next ID or the first ID is beyond that
+	if err := raiseSequence(tx, max(nextID, s.firstID)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
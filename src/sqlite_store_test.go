@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestSQLitePriceMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The products table as the first SQLite release created it, with prices
+	// in a REAL column
+	_, err = db.Exec(`CREATE TABLE products (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		name        TEXT    NOT NULL,
+		description TEXT    NOT NULL DEFAULT '',
+		price       REAL    NOT NULL,
+		stock       INTEGER NOT NULL,
+		category    TEXT    NOT NULL DEFAULT '',
+		image_url   TEXT    NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := []float64{9.99, 0.1 + 0.2, 1299.5, 0.07, 19.999999999}
+	for _, price := range prices {
+		if _, err := db.Exec("INSERT INTO products (name, price, stock) VALUES ('Widget', ?, 1)", price); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	want := []Cents{999, 30, 129950, 7, 2000}
+	// Opening twice checks the migration is a no-op once applied
+	for range 2 {
+		store, err := NewSQLiteStore(path)
+		if err != nil {
+			t.Fatalf("opening legacy database: %v", err)
+		}
+		for i, product := range store.ListProducts() {
+			if product.Price != want[i] {
+				t.Errorf("product %d price = %d cents, want %d", product.ID, product.Price, want[i])
+			}
+		}
+		for column, wantExists := range map[string]bool{"price": false, "price_cents": true} {
+			if exists, err := hasColumn(store.db, "products", column); err != nil || exists != wantExists {
+				t.Errorf("column %s exists = %v, %v; want %v", column, exists, err, wantExists)
+			}
+		}
+		store.Close()
+	}
+}
+
+func TestSQLitePricesExact(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Amounts beyond float64's exact integer range would be rounded by a REAL column
+	const price Cents = 1<<53 + 1
+	created := createProduct(t, store, &Product{Name: "Yacht", Price: price, Currency: "USD", Stock: 1})
+	stored, err := store.GetProduct(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Price != price {
+		t.Errorf("stored price = %d cents, want %d", stored.Price, price)
+	}
+}
+
+func TestSQLiteStoreFailures(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit = float64(rate.Inf)
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewServer(store, cfg).Handler(cfg, NewRateLimiter(cfg.RateLimit))
+	assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Desk","price":1,"stock":1}`), http.StatusCreated)
+
+	// With the database gone every store call fails; none may pass for success or a missing product
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	requests := []struct{ method, target, body string }{
+		{"POST", "/v1/products", `{"name":"Chair","price":1,"stock":1}`},
+		{"POST", "/v1/products/batch", `[{"name":"Chair","price":1,"stock":1}]`},
+		{"GET", "/v1/products/1", ""},
+		{"GET", "/v1/products/1/stock", ""},
+		{"POST", "/v1/products/1/duplicate", ""},
+		{"DELETE", "/v1/products/1", ""},
+		{"POST", "/v1/products/1/restore", ""},
+		{"DELETE", "/v1/products", ""},
+	}
+	for _, req := range requests {
+		assertError(t, serve(h, req.method, req.target, req.body), http.StatusInternalServerError, ErrCodeInternal)
+	}
+	csv := strings.Join(csvHeader, ",") + "\n,Croissant,Buttery,2.50,EUR,3,Bakery,,\n"
+	assertError(t, serve(h, "POST", "/v1/products/import", csv, "Content-Type", "text/csv"), http.StatusInternalServerError, ErrCodeInternal)
+}
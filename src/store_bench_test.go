@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchStoreSize is the number of products the store benchmarks start with
+const benchStoreSize = 10_000
+
+// benchProduct returns a fresh product for the benchmarks to store. Stores
+// keep the pointer they are given, so each write needs its own product.
+func benchProduct() *Product {
+	return &Product{
+		Name:     "Benchmark Widget",
+		Price:    1999,
+		Currency: "USD",
+		Stock:    100,
+		Category: "Benchmarks",
+	}
+}
+
+// newBenchStore returns a ProductStore holding benchStoreSize products with
+// IDs 1 to benchStoreSize
+func newBenchStore(b *testing.B) *ProductStore {
+	b.Helper()
+	store := NewProductStore()
+	products := make([]*Product, benchStoreSize)
+	for i := range products {
+		products[i] = benchProduct()
+	}
+	store.CreateProducts(products)
+	return store
+}
+
+// benchSeed makes each parallel goroutine draw a different ID sequence
+var benchSeed atomic.Uint64
+
+// benchIDs returns a source of random IDs of pre-populated products
+func benchIDs() func() int32 {
+	rng := rand.New(rand.NewPCG(benchSeed.Add(1), 0))
+	return func() int32 { return rng.Int32N(benchStoreSize) + 1 }
+}
+
+func BenchmarkGetProduct(b *testing.B) {
+	store := newBenchStore(b)
+	next := benchIDs()
+	for b.Loop() {
+		store.GetProduct(next())
+	}
+}
+
+func BenchmarkGetProductParallel(b *testing.B) {
+	store := newBenchStore(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		next := benchIDs()
+		for pb.Next() {
+			store.GetProduct(next())
+		}
+	})
+}
+
+func BenchmarkAddOrUpdateProduct(b *testing.B) {
+	store := newBenchStore(b)
+	next := benchIDs()
+	for b.Loop() {
+		store.AddOrUpdateProduct(next(), benchProduct())
+	}
+}
+
+func BenchmarkAddOrUpdateProductParallel(b *testing.B) {
+	store := newBenchStore(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		next := benchIDs()
+		for pb.Next() {
+			store.AddOrUpdateProduct(next(), benchProduct())
+		}
+	})
+}
+
+func BenchmarkCreateProduct(b *testing.B) {
+	store := newBenchStore(b)
+	for b.Loop() {
+		store.CreateProduct(benchProduct())
+	}
+}
+
+func BenchmarkCreateProductParallel(b *testing.B) {
+	store := newBenchStore(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			store.CreateProduct(benchProduct())
+		}
+	})
+}
+
+// BenchmarkMixedParallel models typical traffic: nine reads for every write,
+// spread over the whole store
+func BenchmarkMixedParallel(b *testing.B) {
+	store := newBenchStore(b)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		next := benchIDs()
+		for i := 0; pb.Next(); i++ {
+			if i%10 == 0 {
+				store.AddOrUpdateProduct(next(), benchProduct())
+			} else {
+				store.GetProduct(next())
+			}
+		}
+	})
+}
+
+// singleMutexStore is the unsharded layout ProductStore replaced: every
+// product behind one lock. It exists only as a baseline for the sharding
+// benchmarks.
+type singleMutexStore struct {
+	productShard
+}
+
+func (s *singleMutexStore) GetProduct(id int32) (*Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	product, exists := s.live(id)
+	if !exists {
+		return nil, ErrProductNotFound
+	}
+	return product, nil
+}
+
+func (s *singleMutexStore) AddOrUpdateProduct(id int32, product *Product) (*Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.replace(id, product, 0, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// BenchmarkShardingParallel runs a write-heavy workload, one write for every
+// read, against the sharded store and the single-mutex baseline
+func BenchmarkShardingParallel(b *testing.B) {
+	baseline := &singleMutexStore{productShard{products: make(map[int32]*Product, benchStoreSize)}}
+	for _, product := range newBenchStore(b).ListProducts() {
+		baseline.products[product.ID] = product
+	}
+
+	stores := []struct {
+		name  string
+		store interface {
+			GetProduct(id int32) (*Product, error)
+			AddOrUpdateProduct(id int32, product *Product) (*Product, error)
+		}
+	}{
+		{"sharded", newBenchStore(b)},
+		{"single-mutex", baseline},
+	}
+	for _, bs := range stores {
+		b.Run(bs.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				next := benchIDs()
+				for i := 0; pb.Next(); i++ {
+					if i%2 == 0 {
+						bs.store.AddOrUpdateProduct(next(), benchProduct())
+					} else {
+						bs.store.GetProduct(next())
+					}
+				}
+			})
+		})
+	}
+}
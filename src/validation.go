@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// productSchemaLocation points at the product input schema inside the embedded
+// OpenAPI document, so the published spec and server-side validation share
+// one set of rules
+const productSchemaLocation = "openapi.json#/components/schemas/ProductInput"
+
+// productSchema is compiled once at startup from openAPISpec
+var productSchema = mustCompileProductSchema()
+
+// productFieldOrder fixes the order field errors are reported in
+var productFieldOrder = []string{"id", "name", "description", "price", "currency", "stock", "category", "categories", "imageUrl", "version", "deleted", "deletedAt", "createdAt", "updatedAt"}
+
+// patternMessages explains pattern and format failures, which would otherwise
+// surface as a raw regular expression
+var patternMessages = map[string]string{
+	"imageUrl": "imageUrl must be an absolute http or https URL",
+	"currency": "currency must be a three-letter uppercase ISO 4217 code",
+}
+
+// mustCompileProductSchema compiles the product schema from the embedded spec.
+// OpenAPI 3.0 schemas are a draft 4 dialect, so that draft is used.
+func mustCompileProductSchema() *jsonschema.Schema {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(openAPISpec))
+	if err != nil {
+		panic(fmt.Sprintf("parsing OpenAPI spec: %v", err))
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft4)
+	compiler.AssertFormat()
+	if err := compiler.AddResource("openapi.json", doc); err != nil {
+		panic(fmt.Sprintf("loading OpenAPI spec: %v", err))
+	}
+	schema, err := compiler.Compile(productSchemaLocation)
+	if err != nil {
+		panic(fmt.Sprintf("compiling product schema: %v", err))
+	}
+	return schema
+}
+
+// validateProduct checks product against the product schema and returns all
+// failures found, or nil. Prices with more than two decimal places are
+// rejected rather than rounded, so the stored value is always exactly what
+// the client sent.
+func validateProduct(product *Product) []FieldError {
+	data, err := json.Marshal(product)
+	if err != nil {
+		return []FieldError{{Message: fmt.Sprintf("product could not be encoded: %v", err)}}
+	}
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return []FieldError{{Message: fmt.Sprintf("product could not be encoded: %v", err)}}
+	}
+	return schemaErrors(instance, nil)
+}
+
+// validateProductJSON checks a product as the client sent it against the
+// product schema's required fields, before it is mapped onto Product, where
+// a missing price or stock would read as zero. A field sent as null counts
+// as missing. Everything else is left to the decoder, which rejects mistyped
+// values and, per STRICT_JSON, unknown fields, and to validateProduct, which
+// checks values once they are normalized. Bodies that are not JSON objects
+// are also left to the decoder.
+func validateProductJSON(raw []byte) []FieldError {
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	fields, ok := instance.(map[string]any)
+	if !ok {
+		return nil
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(fields, name)
+		}
+	}
+	return schemaErrors(fields, func(leaf *jsonschema.ValidationError) bool {
+		_, missing := leaf.ErrorKind.(*kind.Required)
+		return missing
+	})
+}
+
+// schemaErrors validates a decoded JSON instance against the product schema,
+// returning its failures in field order, or nil. When keep is non-nil only
+// the failures it accepts are reported.
+func schemaErrors(instance any, keep func(*jsonschema.ValidationError) bool) []FieldError {
+	err := productSchema.Validate(instance)
+	if err == nil {
+		return nil
+	}
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		slog.Error("Product schema validation failed", "error", err)
+		return []FieldError{{Message: "product could not be validated"}}
+	}
+
+	var errs []FieldError
+	seen := make(map[FieldError]bool)
+	for _, leaf := range leafErrors(validationErr) {
+		if keep != nil && !keep(leaf) {
+			continue
+		}
+		fe := schemaFieldError(leaf)
+		if !seen[fe] {
+			seen[fe] = true
+			errs = append(errs, fe)
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return fieldRank(errs[i].Field) < fieldRank(errs[j].Field)
+	})
+	return errs
+}
+
+// validate normalizes product's text fields and fills in the server's default
+// currency when product has none, then checks it against the schema and the
+// server's configured limits, such as MAX_STOCK
+func (s *Server) validate(product *Product) []FieldError {
+	normalizeProduct(product, s.collapseNameSpaces)
+	if product.Currency == "" {
+		product.Currency = s.currency
+	}
+	errs := validateProduct(product)
+	var extra []FieldError
+	if s.maxStock > 0 && product.Stock > s.maxStock {
+		extra = append(extra, FieldError{Field: "stock", Message: fmt.Sprintf("stock must be at most %d", s.maxStock)})
+	}
+	if len(errs) == 0 && !validCurrency(product.Currency) {
+		extra = append(extra, FieldError{Field: "currency", Message: fmt.Sprintf("currency %q is not a known ISO 4217 code", product.Currency)})
+	}
+	if len(extra) > 0 {
+		errs = append(errs, extra...)
+		sort.SliceStable(errs, func(i, j int) bool {
+			return fieldRank(errs[i].Field) < fieldRank(errs[j].Field)
+		})
+	}
+	return errs
+}
+
+// normalizeProduct trims leading and trailing whitespace from product's text
+// fields so "  Laptop  " and "Laptop" are stored alike, which also makes a
+// whitespace-only name fail the required-name check. With collapseSpaces,
+// internal runs of whitespace in the name are reduced to a single space.
+func normalizeProduct(product *Product, collapseSpaces bool) {
+	product.Name = strings.TrimSpace(product.Name)
+	if collapseSpaces {
+		product.Name = strings.Join(strings.Fields(product.Name), " ")
+	}
+	product.Description = strings.TrimSpace(product.Description)
+	product.Currency = strings.TrimSpace(product.Currency)
+	product.Category = strings.TrimSpace(product.Category)
+	for i, category := range product.Categories {
+		product.Categories[i] = strings.TrimSpace(category)
+	}
+	product.ImageURL = strings.TrimSpace(product.ImageURL)
+}
+
+// validCurrency reports whether code is a known ISO 4217 currency code
+func validCurrency(code string) bool {
+	_, err := currency.ParseISO(code)
+	return err == nil
+}
+
+// leafErrors flattens a validation error tree to the errors with no causes,
+// which are the ones naming a specific keyword failure
+func leafErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, leafErrors(cause)...)
+	}
+	return leaves
+}
+
+// schemaFieldError converts a single schema failure into the field-level
+// error reported to clients
+func schemaFieldError(err *jsonschema.ValidationError) FieldError {
+	field := ""
+	if len(err.InstanceLocation) > 0 {
+		field = err.InstanceLocation[0]
+	}
+	// Errors inside an array name the element, e.g. "categories[1]"
+	if len(err.InstanceLocation) > 1 {
+		field = fmt.Sprintf("%s[%s]", field, err.InstanceLocation[1])
+	}
+
+	var msg string
+	switch k := err.ErrorKind.(type) {
+	case *kind.Required:
+		field = k.Missing[0]
+		msg = fmt.Sprintf("%s is required", field)
+	case *kind.AdditionalProperties:
+		field = k.Properties[0]
+		msg = fmt.Sprintf("%s is not a known field", field)
+	case *kind.MinLength:
+		if k.Want == 1 && len(err.InstanceLocation) > 1 {
+			msg = fmt.Sprintf("%s must not be empty", field)
+		} else if k.Want == 1 {
+			msg = fmt.Sprintf("%s is required", field)
+		} else {
+			msg = fmt.Sprintf("%s must be at least %d characters", field, k.Want)
+		}
+	case *kind.MaxLength:
+		msg = fmt.Sprintf("%s must be at most %d characters", field, k.Want)
+	case *kind.MaxItems:
+		msg = fmt.Sprintf("%s must have at most %d entries", field, k.Want)
+	case *kind.Minimum:
+		if k.Want.Sign() == 0 {
+			msg = fmt.Sprintf("%s must be non-negative", field)
+		} else {
+			msg = fmt.Sprintf("%s must be at least %s", field, k.Want.RatString())
+		}
+	case *kind.MultipleOf:
+		if k.Want.Cmp(big.NewRat(1, 100)) == 0 {
+			msg = fmt.Sprintf("%s must have at most two decimal places", field)
+		} else {
+			msg = fmt.Sprintf("%s must be a multiple of %s", field, k.Want.RatString())
+		}
+	case *kind.Pattern, *kind.Format:
+		if pm, ok := patternMessages[field]; ok {
+			msg = pm
+		} else {
+			msg = fmt.Sprintf("%s has an invalid format", field)
+		}
+	default:
+		msg = fmt.Sprintf("%s is invalid: %s", field, err.ErrorKind.LocalizedString(message.NewPrinter(language.English)))
+	}
+	return FieldError{Field: field, Message: msg}
+}
+
+// fieldRank orders fields as they appear in the product, unknown fields last
+func fieldRank(field string) int {
+	if i := strings.IndexByte(field, '['); i > 0 {
+		field = field[:i]
+	}
+	for i, f := range productFieldOrder {
+		if f == field {
+			return i
+		}
+	}
+	return len(productFieldOrder)
+}
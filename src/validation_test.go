@@ -0,0 +1,289 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fieldErrorsFor returns the messages validateProduct reports for field
+func fieldErrorsFor(product *Product, field string) []string {
+	var msgs []string
+	for _, fe := range validateProduct(product) {
+		if fe.Field == field {
+			msgs = append(msgs, fe.Message)
+		}
+	}
+	return msgs
+}
+
+func TestValidateTextLengths(t *testing.T) {
+	tests := []struct {
+		name        string
+		productName string
+		description string
+		wantField   string // "" when the product is valid
+		wantMessage string
+	}{
+		{"name at limit", strings.Repeat("n", 200), "", "", ""},
+		{"name over limit", strings.Repeat("n", 201), "", "name", "name must be at most 200 characters"},
+		{"multibyte name at limit", strings.Repeat("é", 200), "", "", ""},
+		{"multibyte name over limit", strings.Repeat("日", 201), "", "name", "name must be at most 200 characters"},
+		{"empty name", "", "", "name", "name is required"},
+		{"description at limit", "Widget", strings.Repeat("d", 2000), "", ""},
+		{"multibyte description at limit", "Widget", strings.Repeat("ü", 2000), "", ""},
+		{"description over limit", "Widget", strings.Repeat("d", 2001), "description", "description must be at most 2000 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := &Product{Name: tt.productName, Description: tt.description, Price: 100, Currency: "USD"}
+			errs := validateProduct(product)
+			if tt.wantField == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %+v", errs)
+				}
+				return
+			}
+			msgs := fieldErrorsFor(product, tt.wantField)
+			if len(msgs) != 1 || msgs[0] != tt.wantMessage {
+				t.Errorf("%s errors = %q, want [%q]; all errors: %+v", tt.wantField, msgs, tt.wantMessage, errs)
+			}
+		})
+	}
+}
+
+func TestCreateProductTextTooLong(t *testing.T) {
+	_, h := newTestServer(t)
+	rec := serve(h, "POST", "/v1/products", `{"name":"`+strings.Repeat("n", 201)+`","price":1,"stock":1}`)
+	assertFieldError(t, rec, "name")
+	rec = serve(h, "POST", "/v1/products", `{"name":"Widget","description":"`+strings.Repeat("d", 2001)+`","price":1,"stock":1}`)
+	assertFieldError(t, rec, "description")
+}
+
+func TestRequiredFields(t *testing.T) {
+	tests := []struct {
+		name, method, target, body, wantField string
+	}{
+		{"create missing price", "POST", "/v1/products", `{"name":"Desk","stock":1}`, "price"},
+		{"create missing stock", "POST", "/v1/products", `{"name":"Desk","price":1}`, "stock"},
+		{"create null price", "POST", "/v1/products", `{"name":"Desk","price":null,"stock":1}`, "price"},
+		{"replace missing price", "PUT", "/v1/products/1", `{"name":"Laptop","stock":1}`, "price"},
+		{"replace missing stock", "PUT", "/v1/products/1", `{"name":"Laptop","price":1}`, "stock"},
+		{"details missing stock", "POST", "/v1/products/1/details", `{"name":"Laptop","price":1}`, "stock"},
+		{"batch missing price", "POST", "/v1/products/batch", `[{"name":"Desk","price":1,"stock":1},{"name":"Chair","stock":1}]`, "[1].price"},
+		{"batch missing stock", "POST", "/v1/products/batch", `[{"name":"Desk","price":1}]`, "[0].stock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t)
+			rec := serve(h, tt.method, tt.target, tt.body)
+			assertFieldError(t, rec, tt.wantField)
+			if msgs := decodeBody[ValidationError](t, rec).Errors; len(msgs) != 1 || !strings.HasSuffix(msgs[0].Message, "is required") {
+				t.Errorf("errors = %+v, want only %s reported as required", msgs, tt.wantField)
+			}
+			if stats := decodeBody[StoreStats](t, serve(h, "GET", "/stats", "")); stats.Products != 3 {
+				t.Errorf("products = %d after a rejected request, want 3", stats.Products)
+			}
+			if product := getProduct(t, h, "1"); product.Version != 1 {
+				t.Errorf("product 1 = %+v after a rejected request", product)
+			}
+		})
+	}
+
+	// In a batch update only the incomplete item fails
+	_, h := newTestServer(t)
+	rec := serve(h, "POST", "/v1/products/batch-update", `[{"id":1,"name":"Laptop","stock":1},{"id":2,"name":"Mouse","price":1,"stock":1}]`)
+	assertStatus(t, rec, http.StatusOK)
+	results := decodeBody[BatchUpdateResult](t, rec).Results
+	if len(results) != 2 || results[0].Status != http.StatusUnprocessableEntity || len(results[0].Errors) != 1 ||
+		results[0].Errors[0].Field != "price" || results[0].ID != 1 || results[1].Status != http.StatusOK {
+		t.Errorf("results = %+v, want item 0 missing its price and item 1 updated", results)
+	}
+}
+
+func TestValidateImageURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"", false},
+		{"https://cdn.example.com/laptop.png", false},
+		{"http://example.com/a.jpg?size=large", false},
+		{"javascript:alert(1)", true},
+		{"ftp://example.com/laptop.png", true},
+		{"data:image/png;base64,AAAA", true},
+		{"/images/laptop.png", true},
+		{"https://", true},
+		{"https://exa mple.com/a.png", true},
+		{"not a url", true},
+	}
+	for _, tt := range tests {
+		product := &Product{Name: "Widget", Price: 100, Currency: "USD", ImageURL: tt.url}
+		msgs := fieldErrorsFor(product, "imageUrl")
+		if gotErr := len(msgs) > 0; gotErr != tt.wantErr {
+			t.Errorf("imageUrl %q: errors = %q, want error %v", tt.url, msgs, tt.wantErr)
+		}
+		if tt.wantErr && len(msgs) > 0 && msgs[0] != patternMessages["imageUrl"] {
+			t.Errorf("imageUrl %q: message = %q, want %q", tt.url, msgs[0], patternMessages["imageUrl"])
+		}
+	}
+}
+
+func TestMaxStock(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"create at cap", "POST", "/v1/products", `{"name":"Widget","price":1,"stock":100}`, http.StatusCreated},
+		{"create over cap", "POST", "/v1/products", `{"name":"Widget","price":1,"stock":101}`, http.StatusUnprocessableEntity},
+		{"replace at cap", "PUT", "/v1/products/1", `{"name":"Laptop","price":1,"stock":100}`, http.StatusOK},
+		{"replace over cap", "PUT", "/v1/products/1", `{"name":"Laptop","price":1,"stock":101}`, http.StatusUnprocessableEntity},
+		{"details over cap", "POST", "/v1/products/1/details", `{"name":"Laptop","price":1,"stock":101}`, http.StatusUnprocessableEntity},
+		{"adjust up to cap", "POST", "/v1/products/1/stock/adjust", `{"delta":90}`, http.StatusOK},
+		{"adjust over cap", "POST", "/v1/products/1/stock/adjust", `{"delta":91}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) { cfg.MaxStock = 100 })
+			rec := serve(h, tt.method, tt.target, tt.body)
+			switch tt.wantStatus {
+			case http.StatusUnprocessableEntity:
+				assertFieldError(t, rec, "stock")
+			case http.StatusConflict:
+				assertError(t, rec, tt.wantStatus, ErrCodeStockLimitExceeded)
+				assertStock(t, h, "1", 10)
+			default:
+				assertStatus(t, rec, tt.wantStatus)
+			}
+		})
+	}
+
+	// Without MAX_STOCK only the int32 range applies
+	_, h := newTestServer(t)
+	assertStatus(t, serve(h, "POST", "/v1/products", `{"name":"Widget","price":1,"stock":2147483647}`), http.StatusCreated)
+}
+
+func TestProductCurrency(t *testing.T) {
+	tests := []struct {
+		name         string
+		defaultCode  string
+		currency     string // JSON value of the currency field; "" omits it
+		wantCurrency string // "" when the product is rejected
+	}{
+		{"valid", "USD", `"EUR"`, "EUR"},
+		{"defaulted", "USD", "", "USD"},
+		{"configured default", "GBP", "", "GBP"},
+		{"surrounding spaces trimmed", "USD", `" JPY "`, "JPY"},
+		{"unknown code", "USD", `"XYZ"`, ""},
+		{"lower case", "USD", `"eur"`, ""},
+		{"too long", "USD", `"EURO"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, h := newTestServer(t, func(cfg *Config) { cfg.Currency = tt.defaultCode })
+			body := `{"name":"Widget","price":1,"stock":1}`
+			if tt.currency != "" {
+				body = `{"name":"Widget","price":1,"stock":1,"currency":` + tt.currency + `}`
+			}
+			rec := serve(h, "POST", "/v1/products", body)
+			if tt.wantCurrency == "" {
+				assertFieldError(t, rec, "currency")
+				return
+			}
+			assertStatus(t, rec, http.StatusCreated)
+			if product := decodeBody[Product](t, rec); product.Currency != tt.wantCurrency {
+				t.Errorf("currency = %q, want %q", product.Currency, tt.wantCurrency)
+			}
+		})
+	}
+}
+
+func TestValidateCategoryTags(t *testing.T) {
+	tags := func(n int) []string {
+		categories := make([]string, n)
+		for i := range categories {
+			categories[i] = "Tag"
+		}
+		return categories
+	}
+	tests := []struct {
+		name        string
+		categories  []string
+		wantField   string // "" when the product is valid
+		wantMessage string
+	}{
+		{"no tags", nil, "", ""},
+		{"at limit", tags(10), "", ""},
+		{"over limit", tags(11), "categories", "categories must have at most 10 entries"},
+		{"empty tag", []string{"Office", ""}, "categories[1]", "categories[1] must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := &Product{Name: "Widget", Price: 100, Currency: "USD", Categories: tt.categories}
+			errs := validateProduct(product)
+			if tt.wantField == "" {
+				if len(errs) > 0 {
+					t.Fatalf("unexpected errors: %+v", errs)
+				}
+				return
+			}
+			msgs := fieldErrorsFor(product, tt.wantField)
+			if len(msgs) != 1 || msgs[0] != tt.wantMessage {
+				t.Errorf("%s errors = %q, want [%q]; all errors: %+v", tt.wantField, msgs, tt.wantMessage, errs)
+			}
+		})
+	}
+
+	// A tag of only whitespace is trimmed to empty before validation
+	_, h := newTestServer(t)
+	assertFieldError(t, serve(h, "POST", "/v1/products", `{"name":"Widget","price":1,"stock":1,"categories":["Office","  "]}`), "categories[1]")
+}
+
+func TestNormalizeProduct(t *testing.T) {
+	tests := []struct {
+		name     string
+		collapse bool
+		input    string
+		wantName string
+	}{
+		{"trimmed", false, "  Laptop  ", "Laptop"},
+		{"tabs and newlines", false, "\tLaptop\n", "Laptop"},
+		{"internal spaces kept", false, " Gaming   Laptop ", "Gaming   Laptop"},
+		{"internal spaces collapsed", true, " Gaming \t  Laptop ", "Gaming Laptop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := &Product{Name: tt.input, Description: "  Fast  ", Currency: " USD ", Category: " Electronics ", Categories: []string{" Office "}, ImageURL: " https://example.com/a.png "}
+			normalizeProduct(product, tt.collapse)
+			if product.Name != tt.wantName {
+				t.Errorf("name = %q, want %q", product.Name, tt.wantName)
+			}
+			if product.Description != "Fast" || product.Currency != "USD" || product.Category != "Electronics" ||
+				product.Categories[0] != "Office" || product.ImageURL != "https://example.com/a.png" {
+				t.Errorf("fields not trimmed: %+v", product)
+			}
+		})
+	}
+}
+
+func TestCreateProductNormalized(t *testing.T) {
+	_, h := newTestServer(t)
+	rec := serve(h, "POST", "/v1/products", `{"name":"  Laptop  ","price":1,"stock":1,"category":" Electronics "}`)
+	assertStatus(t, rec, http.StatusCreated)
+	created := decodeBody[Product](t, rec)
+	if stored := getProduct(t, h, "4"); created.Name != "Laptop" || stored.Name != "Laptop" || stored.Category != "Electronics" {
+		t.Errorf("created %q, stored %+v; want Laptop in Electronics", created.Name, stored)
+	}
+
+	// A name of only whitespace is missing once trimmed
+	assertFieldError(t, serve(h, "POST", "/v1/products", `{"name":"   ","price":1,"stock":1}`), "name")
+
+	_, h = newTestServer(t, func(cfg *Config) { cfg.CollapseNameSpaces = true })
+	assertStatus(t, serve(h, "PUT", "/v1/products/1", `{"name":" Gaming    Laptop ","price":1,"stock":1}`), http.StatusOK)
+	if stored := getProduct(t, h, "1"); stored.Name != "Gaming Laptop" {
+		t.Errorf("name = %q, want Gaming Laptop", stored.Name)
+	}
+}